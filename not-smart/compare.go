@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"evolve/debugger"
+	"evolve/wikipedia/history/preprocessor"
+	"evolve/wikipedia/history/scraper"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Cleans the scraped revisions with the native cleaner and pandoc and reports how close they are.
+// With -min it's a check that the native cleaner didn't drift away from pandoc.
+func runCompare(cfg *config) error {
+	titles, err := cfg.collectTitles(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	wiki, err := cfg.wiki()
+	if err != nil {
+		return err
+	}
+
+	debugger, err := debugger.NewDebugger()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	native := preprocessor.NewNativeBackend()
+	pandoc, err := preprocessor.NewPandocBackend(ctx, cfg.pandocServers, nil, debugger)
+	if err != nil {
+		return err
+	}
+	defer pandoc.Close()
+
+	var sum float64
+	count := 0
+	for _, title := range titles {
+		revsDir := filepath.Join(scraper.DumpDir(cfg.dumpRoot, wiki, title), "revs")
+		entries, err := os.ReadDir(revsDir)
+		if err != nil {
+			return fmt.Errorf("%s: %w", title, err)
+		}
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+				names = append(names, entry.Name())
+			}
+		}
+		// "<timestamp>-<revid>.json", newest first
+		slices.Sort(names)
+		slices.Reverse(names)
+		if cfg.limit > 0 && len(names) > cfg.limit {
+			names = names[:cfg.limit]
+		}
+
+		fmt.Printf("%s:\n", title)
+		for _, name := range names {
+			data, err := os.ReadFile(filepath.Join(revsDir, name))
+			if err != nil {
+				return err
+			}
+			rev := new(preprocessor.RevisionContent)
+			if err = json.Unmarshal(data, rev); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+
+			nativeTxt, err := native.Clean(ctx, rev.Slots.Main.Content)
+			if err != nil {
+				return err
+			}
+			pandocTxt, err := pandoc.Clean(ctx, rev.Slots.Main.Content)
+			if err != nil {
+				return fmt.Errorf("pandoc %d: %w", rev.RevID, err)
+			}
+
+			sim := preprocessor.WordSimilarity(nativeTxt, pandocTxt)
+			fmt.Printf("  %d: %.3f (%d / %d words)\n", rev.RevID, sim, len(strings.Fields(nativeTxt)), len(strings.Fields(pandocTxt)))
+			sum += sim
+			count++
+		}
+	}
+	if count == 0 {
+		return fmt.Errorf("no scraped revisions to compare")
+	}
+
+	mean := sum / float64(count)
+	fmt.Printf("\nmean similarity: %.3f over %d revisions\n", mean, count)
+	if mean < cfg.minSimilarity {
+		return fmt.Errorf("mean similarity %.3f is below %.3f", mean, cfg.minSimilarity)
+	}
+	return nil
+}
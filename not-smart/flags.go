@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"evolve/wikipedia/history"
+	"evolve/wikipedia/history/preprocessor"
+	"evolve/wikipedia/history/scraper"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Exit codes
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+	// 128 + SIGINT, like a shell reports it
+	exitInterrupted = 130
+)
+
+// A flag that can be repeated, -title A -title B
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringList) Set(val string) error {
+	*s = append(*s, val)
+	return nil
+}
+
+// Flags shared by the subcommands, each registers the ones it uses
+type config struct {
+	// Set by titleFlags, the commands without them don't need titles
+	needTitles bool
+	titles     stringList
+	titlesFile string
+	category   string
+	depth      int
+	dumpRoot   string
+
+	// Wiki
+	lang    string
+	project string
+	wikiURL string
+
+	// Politeness
+	rate   int
+	maxLag int
+
+	// Scrape
+	parallel int
+	resume   bool
+	update   bool
+	since    string
+	until    string
+
+	// Process
+	workers       int
+	cleaner       string
+	pandocServers int
+	rulesFile     string
+	// Loaded from rulesFile by validate
+	rules        *preprocessor.Rules
+	usersTTL     time.Duration
+	refreshUsers bool
+	// Opened by the commands that process, see openUserStore
+	users *preprocessor.UserStore
+
+	// All
+	stream bool
+
+	// Compare
+	limit         int
+	minSimilarity float64
+
+	// Offline runs
+	record string
+	replay string
+}
+
+func (s *config) titleFlags(fs *flag.FlagSet) {
+	s.needTitles = true
+	fs.Var(&s.titles, "title", "article title, repeat for several")
+	fs.StringVar(&s.titlesFile, "titles-file", "", "file with one article title per line")
+	fs.StringVar(&s.dumpRoot, "dump", "dump", "root directory of the dumps, one sub directory per wiki then per title")
+}
+
+// Which wiki, every command needs it to find the dumps
+func (s *config) wikiFlags(fs *flag.FlagSet) {
+	fs.StringVar(&s.lang, "lang", "en", "wiki language code")
+	fs.StringVar(&s.project, "project", "wikipedia", "wiki project, e.g. wikipedia, wiktionary, wikibooks")
+	fs.StringVar(&s.wikiURL, "wiki", "", "wiki host (de.wikipedia.org) or full api.php URL, overrides -lang and -project")
+}
+
+func (s *config) clientFlags(fs *flag.FlagSet) {
+	fs.IntVar(&s.rate, "rate", 3, "max API requests per second, shared by every stage")
+	fs.IntVar(&s.maxLag, "maxlag", 5, "maxlag sent to the API in seconds, 0 to not send it")
+	fs.StringVar(&s.record, "record", "", "save every API response to this directory")
+	fs.StringVar(&s.replay, "replay", "", "serve API responses recorded in this directory, no network")
+}
+
+// Only for the commands with a client, the members are listed through the API
+func (s *config) categoryFlags(fs *flag.FlagSet) {
+	fs.StringVar(&s.category, "category", "", "every article of this category")
+	fs.IntVar(&s.depth, "depth", 0, "levels of sub categories of -category to descend")
+}
+
+func (s *config) scrapeFlags(fs *flag.FlagSet) {
+	fs.IntVar(&s.parallel, "parallel", 2, "pages scraped at the same time, sharing the rate limit")
+	fs.BoolVar(&s.resume, "resume", false, "continue a previous scrape from its 0ids.json index and 0config.txt checkpoint")
+	fs.BoolVar(&s.update, "update", false, "only fetch the revisions newer than the newest one in the dump")
+	fs.StringVar(&s.since, "since", "", "only revisions saved at or after this time (2006-01-02 or RFC3339)")
+	fs.StringVar(&s.until, "until", "", "only revisions saved at or before this time (2006-01-02 or RFC3339)")
+}
+
+func (s *config) processFlags(fs *flag.FlagSet) {
+	fs.IntVar(&s.workers, "workers", 40, "revisions analysed concurrently")
+	fs.StringVar(&s.cleaner, "cleaner", preprocessor.CleanerNative, "wikitext to plain text backend, native or pandoc (needs pandoc-server)")
+	fs.IntVar(&s.pandocServers, "pandoc-servers", 3, "pandoc-server processes used by the pandoc cleaner")
+	fs.DurationVar(&s.usersTTL, "users-ttl", preprocessor.DefaultUserTTL, "fetch stored users again once they're older than this, 0 to keep them forever")
+	fs.BoolVar(&s.refreshUsers, "refresh-users", false, "fetch every user again once, whatever -users-ttl says")
+	s.rulesFlags(fs)
+}
+
+func (s *config) rulesFlags(fs *flag.FlagSet) {
+	fs.StringVar(&s.rulesFile, "rules", "", "JSON file of confidence rules, its sections replace the built-in ones, see evolve rules")
+}
+
+func (s *config) allFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&s.stream, "stream", false, "process every revision as soon as it's scraped, one title at a time")
+}
+
+func (s *config) compareFlags(fs *flag.FlagSet) {
+	fs.IntVar(&s.limit, "limit", 0, "revisions compared per title, newest first, 0 for all")
+	fs.Float64Var(&s.minSimilarity, "min", 0, "fail if the mean word similarity is below this, 0 to 1")
+	fs.IntVar(&s.pandocServers, "pandoc-servers", 3, "pandoc-server processes")
+}
+
+func (s *config) validate() error {
+	if s.needTitles && len(s.titles) == 0 && s.titlesFile == "" && s.category == "" {
+		return fmt.Errorf("at least one -title, a -titles-file or a -category is required")
+	}
+	if s.depth < 0 {
+		return fmt.Errorf("-depth can't be negative")
+	}
+	if s.resume && s.update {
+		return fmt.Errorf("-resume and -update are exclusive")
+	}
+	if s.stream && s.resume {
+		return fmt.Errorf("-stream pages oldest first and can't -resume, continue it with -update")
+	}
+	if s.cleaner != "" && s.cleaner != preprocessor.CleanerNative && s.cleaner != preprocessor.CleanerPandoc {
+		return fmt.Errorf("-cleaner is %s or %s", preprocessor.CleanerNative, preprocessor.CleanerPandoc)
+	}
+	if s.record != "" && s.replay != "" {
+		return fmt.Errorf("-record and -replay are exclusive")
+	}
+	if s.rate < 0 {
+		return fmt.Errorf("-rate can't be negative")
+	}
+	if s.usersTTL < 0 {
+		return fmt.Errorf("-users-ttl can't be negative")
+	}
+	if _, err := s.wiki(); err != nil {
+		return err
+	}
+	if s.rulesFile != "" {
+		rules, err := preprocessor.LoadRules(s.rulesFile)
+		if err != nil {
+			return fmt.Errorf("-rules: %v", err)
+		}
+		s.rules = rules
+	}
+	return nil
+}
+
+func (s *config) wiki() (*history.Wiki, error) {
+	if s.wikiURL != "" {
+		return history.ParseWiki(s.wikiURL)
+	}
+	if s.lang == "en" && s.project == "wikipedia" {
+		return history.DefaultWiki(), nil
+	}
+	return history.WikimediaWiki(s.lang, s.project)
+}
+
+func (s *config) scrapeOptions() (*scraper.Options, error) {
+	opts := &scraper.Options{
+		Resume: s.resume,
+		Update: s.update,
+	}
+	var err error
+	if opts.Since, err = parseTime(s.since, false); err != nil {
+		return nil, err
+	}
+	if opts.Until, err = parseTime(s.until, true); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+func (s *config) processOptions() *preprocessor.Options {
+	return &preprocessor.Options{
+		Workers:       s.workers,
+		Cleaner:       s.cleaner,
+		PandocServers: s.pandocServers,
+		Rules:         s.rules,
+		Users:         s.users,
+	}
+}
+
+// Titles from -title, -titles-file and -category in that order, without duplicates.
+// client is only used for -category.
+func (s *config) collectTitles(ctx context.Context, client *history.Client) ([]string, error) {
+	titles := slices.Clone([]string(s.titles))
+
+	if s.titlesFile != "" {
+		fromFile, err := scraper.ReadTitlesFile(s.titlesFile)
+		if err != nil {
+			return nil, err
+		}
+		titles = append(titles, fromFile...)
+	}
+	if s.category != "" {
+		members, err := scraper.CategoryTitles(ctx, client, s.category, s.depth)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("%s: %d articles\n", s.category, len(members))
+		titles = append(titles, members...)
+	}
+
+	seen := make(map[string]bool, len(titles))
+	unique := make([]string, 0, len(titles))
+	for _, title := range titles {
+		if !seen[title] {
+			seen[title] = true
+			unique = append(unique, title)
+		}
+	}
+	if len(unique) == 0 {
+		return nil, fmt.Errorf("no titles to work on")
+	}
+
+	return unique, nil
+}
+
+// Accepts a date or a full RFC3339 timestamp, empty is the zero time.
+// With endOfDay a date is its last nanosecond, so -until 2024-01-31 keeps the edits of that day.
+func parseTime(str string, endOfDay bool) (time.Time, error) {
+	if str == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, str); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, str)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad time %q, expected 2006-01-02 or RFC3339", str)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
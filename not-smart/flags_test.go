@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	cases := []struct {
+		str      string
+		endOfDay bool
+		want     time.Time
+	}{
+		{"", false, time.Time{}},
+		{"", true, time.Time{}},
+		{"2024-01-31", false, time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)},
+		// -until keeps the whole day
+		{"2024-01-31", true, time.Date(2024, 1, 31, 23, 59, 59, 999999999, time.UTC)},
+		// A full timestamp is taken as is
+		{"2024-01-31T12:00:00Z", true, time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		got, err := parseTime(c.str, c.endOfDay)
+		if err != nil {
+			t.Fatalf("%q: %v", c.str, err)
+		}
+		if !got.Equal(c.want) {
+			t.Errorf("parseTime(%q, %v) = %v, want %v", c.str, c.endOfDay, got, c.want)
+		}
+	}
+
+	if _, err := parseTime("31/01/2024", false); err == nil {
+		t.Error("no error for a bad time")
+	}
+}
@@ -2,9 +2,9 @@ module evolve
 
 go 1.25.1
 
-require golang.org/x/sync v0.19.0
-
 require (
-	github.com/m-m-f/gowiki v0.0.0-20180103212159-93aa7513fb32 // indirect
-	github.com/sergi/go-diff v1.4.0 // indirect
+	github.com/sergi/go-diff v1.4.0
+	golang.org/x/sync v0.19.0
 )
+
+require github.com/m-m-f/gowiki v0.0.0-20180103212159-93aa7513fb32 // indirect
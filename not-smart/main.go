@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"evolve/debugger"
+	"evolve/wikipedia/history"
 	"evolve/wikipedia/history/compressor"
 	"evolve/wikipedia/history/preprocessor"
 	"evolve/wikipedia/history/scraper"
@@ -13,65 +16,354 @@ import (
 	"syscall"
 )
 
+const usage = `Usage: evolve <command> [flags]
+
+Commands:
+  scrape     fetch the revision history of the titles, several pages at a time
+  process    clean, diff and analyse the scraped revisions
+  compress   concatenate the cleaned revisions into compress.txt
+  all        scrape, process and compress one after the other, or -stream them together
+  status     show what is already in the dump of the titles
+  compare    clean the scraped revisions with both cleaners and compare them, needs pandoc-server
+  rules      print the confidence rules as JSON, the built-in ones or a -rules file over them
+
+Each stage exits once it is done, Ctrl-C stops it early.
+Run "evolve <command> -h" for the flags of a command.
+`
+
 func main() {
-	flag.Parse()
-	args := flag.Args()
+	os.Exit(run(os.Args[1:]))
+}
 
+func run(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+	cmd := args[0]
+	if cmd == "help" || cmd == "-h" || cmd == "-help" || cmd == "--help" {
+		fmt.Print(usage)
+		return exitOK
+	}
+
+	cfg := new(config)
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: evolve %s [flags]\n\nFlags:\n", cmd)
+		fs.PrintDefaults()
+	}
+
+	var exec func(*config) error
+	switch cmd {
+	case "scrape":
+		cfg.titleFlags(fs)
+		cfg.categoryFlags(fs)
+		cfg.wikiFlags(fs)
+		cfg.clientFlags(fs)
+		cfg.scrapeFlags(fs)
+		exec = runScrape
+	case "process":
+		cfg.titleFlags(fs)
+		cfg.categoryFlags(fs)
+		cfg.wikiFlags(fs)
+		cfg.clientFlags(fs)
+		cfg.processFlags(fs)
+		exec = runProcess
+	case "compress":
+		cfg.titleFlags(fs)
+		cfg.wikiFlags(fs)
+		exec = runCompress
+	case "all":
+		cfg.titleFlags(fs)
+		cfg.categoryFlags(fs)
+		cfg.wikiFlags(fs)
+		cfg.clientFlags(fs)
+		cfg.scrapeFlags(fs)
+		cfg.processFlags(fs)
+		cfg.allFlags(fs)
+		exec = runAll
+	case "status":
+		cfg.titleFlags(fs)
+		cfg.wikiFlags(fs)
+		exec = runStatus
+	case "compare":
+		cfg.titleFlags(fs)
+		cfg.wikiFlags(fs)
+		cfg.compareFlags(fs)
+		exec = runCompare
+	case "rules":
+		cfg.rulesFlags(fs)
+		exec = runRules
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		return exitUsage
+	}
+
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if err := cfg.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		fs.Usage()
+		return exitUsage
+	}
+
+	if err := exec(cfg); err != nil {
+		if errors.Is(err, errInterrupted) {
+			fmt.Fprintln(os.Stderr, "\nInterrupted")
+			return exitInterrupted
+		}
+		fmt.Fprintf(os.Stderr, "\nERROR: %v\n", err)
+		return exitError
+	}
+
+	return exitOK
+}
+
+var errInterrupted = errors.New("interrupted")
+
+// A running stage
+type stage interface {
+	Done() <-chan struct{}
+	Wait() error
+	Stop() error
+}
+
+// Blocks until the stage is done, Ctrl-C or SIGTERM stop it early and return errInterrupted
+func await(name string, st stage) error {
 	flowChan := make(chan os.Signal, 1)
 	signal.Notify(flowChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(flowChan)
 
-	wd, err := os.Getwd()
-	if err != nil {
-		panic(err)
+	select {
+	case <-st.Done():
+		return st.Wait()
+	case <-flowChan:
+		fmt.Printf("Stopping the %s\n", name)
+		if err := st.Stop(); err != nil {
+			return err
+		}
+		return errInterrupted
 	}
+}
 
-	title := "Machine learning"
+// The client shared by every stage, with the offline transports if asked for
+func newClient(cfg *config, debugger *debugger.Debugger) (*history.Client, error) {
+	wiki, err := cfg.wiki()
+	if err != nil {
+		return nil, err
+	}
 
-	debugger, err := debugger.NewDebugger()
+	client, err := history.NewClient(wiki, cfg.rate, debugger)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	client.MaxLag = cfg.maxLag
 
-	switch args[0] {
-	case "scrape":
-		scraper, err := scraper.NewWikiScrape(title, filepath.Join(wd, "dump", "wikipedia"), debugger)
+	switch {
+	case cfg.record != "":
+		transport, err := history.NewRecordTransport(cfg.record)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		if err := scraper.Run(); err != nil {
-			panic(err)
+		client.SetTransport(transport)
+	case cfg.replay != "":
+		transport, err := history.NewReplayTransport(cfg.replay)
+		if err != nil {
+			return nil, err
 		}
-		<-flowChan
-		fmt.Println("Stopping the scraper")
-		if err := scraper.Stop(); err != nil {
-			panic(err)
+		client.SetTransport(transport)
+	}
+
+	return client, nil
+}
+
+func runScrape(cfg *config) error {
+	debugger, err := debugger.NewDebugger()
+	if err != nil {
+		return err
+	}
+	client, err := newClient(cfg, debugger)
+	if err != nil {
+		return err
+	}
+
+	titles, err := cfg.collectTitles(context.Background(), client)
+	if err != nil {
+		return err
+	}
+
+	_, err = scrape(cfg, titles, client, debugger)
+	return err
+}
+
+func runProcess(cfg *config) error {
+	debugger, err := debugger.NewDebugger()
+	if err != nil {
+		return err
+	}
+	client, err := newClient(cfg, debugger)
+	if err != nil {
+		return err
+	}
+
+	titles, err := cfg.collectTitles(context.Background(), client)
+	if err != nil {
+		return err
+	}
+
+	closeUsers, err := openUserStore(cfg, client.Wiki())
+	if err != nil {
+		return err
+	}
+	defer closeUsers()
+
+	for _, title := range titles {
+		if err := process(cfg, title, client, debugger); err != nil {
+			return fmt.Errorf("%s: %w", title, err)
 		}
-		scraper.PrintMetrics()
-	case "process":
-		dumpDir := filepath.Join(wd, "dump", "wikipedia", title)
-		preprocessor, err := preprocessor.NewWikiPreprocessor(filepath.Join(wd, "dump", "wikipedia", title, "0ids.json"), nil, dumpDir, debugger)
-		if err != nil {
-			panic(err)
+	}
+	return nil
+}
+
+func runCompress(cfg *config) error {
+	titles, err := cfg.collectTitles(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+
+	for _, title := range titles {
+		if err := compress(cfg, title); err != nil {
+			return fmt.Errorf("%s: %w", title, err)
 		}
-		if err := preprocessor.Run(); err != nil {
-			panic(err)
+	}
+	return nil
+}
+
+// Scrapes every title first, then processes and compresses the ones that were scraped
+func runAll(cfg *config) error {
+	debugger, err := debugger.NewDebugger()
+	if err != nil {
+		return err
+	}
+	client, err := newClient(cfg, debugger)
+	if err != nil {
+		return err
+	}
+
+	titles, err := cfg.collectTitles(context.Background(), client)
+	if err != nil {
+		return err
+	}
+
+	closeUsers, err := openUserStore(cfg, client.Wiki())
+	if err != nil {
+		return err
+	}
+	defer closeUsers()
+
+	if cfg.stream {
+		for _, title := range titles {
+			if err := stream(cfg, title, client, debugger); err != nil {
+				return fmt.Errorf("%s: %w", title, err)
+			}
+			if err := compress(cfg, title); err != nil {
+				return fmt.Errorf("%s: %w", title, err)
+			}
 		}
-		<-flowChan
-		fmt.Println("Stopping the preprocess")
-		if err := preprocessor.Stop(); err != nil {
-			panic(err)
+		return nil
+	}
+
+	scraped, scrapeErr := scrape(cfg, titles, client, debugger)
+	if errors.Is(scrapeErr, errInterrupted) {
+		return scrapeErr
+	}
+
+	for _, title := range scraped {
+		if err := process(cfg, title, client, debugger); err != nil {
+			return fmt.Errorf("%s: %w", title, err)
+		}
+		if err := compress(cfg, title); err != nil {
+			return fmt.Errorf("%s: %w", title, err)
 		}
-		preprocessor.PrintMetrics()
+	}
+	return scrapeErr
+}
 
-	case "compress":
-		dumpDir := filepath.Join(wd, "dump", "wikipedia", title)
-		compressor := compressor.NewCompressor(dumpDir)
-		if err := compressor.Run(); err != nil {
-			panic(err)
+// Opens the user store of the wiki into cfg, every title of the run shares it
+func openUserStore(cfg *config, wiki *history.Wiki) (close func(), err error) {
+	fPath := filepath.Join(scraper.WikiDumpDir(cfg.dumpRoot, wiki), preprocessor.UserStoreFile)
+	cfg.users, err = preprocessor.OpenUserStore(fPath, cfg.usersTTL, cfg.refreshUsers)
+	if err != nil {
+		return nil, fmt.Errorf("user store: %w", err)
+	}
+	return func() {
+		if err := cfg.users.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "closing the user store: %v\n", err)
 		}
-		<-flowChan
-		fmt.Println("Stopping the compressor")
+	}, nil
+}
+
+// Returns the titles scraped without an error
+func scrape(cfg *config, titles []string, client *history.Client, debugger *debugger.Debugger) ([]string, error) {
+	opts, err := cfg.scrapeOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	batch := scraper.NewBatch(titles, cfg.dumpRoot, cfg.parallel, opts, client, debugger)
+	if err := batch.Run(); err != nil {
+		return nil, err
+	}
+	err = await("scraper", batch)
+	batch.PrintMetrics()
+	return batch.Completed(), err
+}
+
+func process(cfg *config, title string, client *history.Client, debugger *debugger.Debugger) error {
+	opts := cfg.processOptions()
+
+	dumpDir := scraper.DumpDir(cfg.dumpRoot, client.Wiki(), title)
+	preprocessor, err := preprocessor.NewWikiPreprocessor(filepath.Join(dumpDir, "0ids.json"), nil, dumpDir, opts, client, debugger)
+	if err != nil {
+		return err
+	}
+	if err := preprocessor.Run(); err != nil {
+		return err
+	}
+	err = await("preprocess", preprocessor)
+	preprocessor.PrintMetrics()
+	return err
+}
+
+// Starting point of a -rules file
+func runRules(cfg *config) error {
+	rules := cfg.rules
+	if rules == nil {
+		rules = preprocessor.DefaultRules()
+	}
+	data, err := rules.JSON()
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func compress(cfg *config, title string) error {
+	wiki, err := cfg.wiki()
+	if err != nil {
+		return err
+	}
+	dumpDir := scraper.DumpDir(cfg.dumpRoot, wiki, title)
+	compressor := compressor.NewCompressor(dumpDir)
+	if err := compressor.Run(); err != nil {
+		return err
 	}
+	return await("compressor", compressor)
 }
 
 /*
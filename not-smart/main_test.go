@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"evolve/wikipedia/history"
+	"evolve/wikipedia/history/mwtest"
+	"evolve/wikipedia/history/preprocessor"
+	"evolve/wikipedia/history/scraper"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Starts the fake wiki of mwtest/testdata and moves into a temporary directory,
+// the debug logs and the dumps land there
+func fakeWiki(t *testing.T) (*mwtest.Wiki, *mwtest.Server) {
+	t.Helper()
+
+	wiki, err := mwtest.LoadWiki(filepath.Join("wikipedia", "history", "mwtest", "testdata", "wiki.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := mwtest.NewServer(wiki)
+	t.Cleanup(server.Close)
+	t.Chdir(t.TempDir())
+	return wiki, server
+}
+
+func fakePage(t *testing.T, wiki *mwtest.Wiki, title string) *mwtest.Page {
+	t.Helper()
+
+	for _, page := range wiki.Pages {
+		if page.Title == title {
+			return page
+		}
+	}
+	t.Fatalf("no page %q in the fake wiki", title)
+	return nil
+}
+
+func runOK(t *testing.T, args ...string) {
+	t.Helper()
+
+	if code := run(args); code != exitOK {
+		t.Fatalf("evolve %s exited with %d", strings.Join(args, " "), code)
+	}
+}
+
+func countDir(t *testing.T, dir string) int {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestScrapeProcessCompress(t *testing.T) {
+	const title = "Machine learning"
+	wiki, server := fakeWiki(t)
+	page := fakePage(t, wiki, title)
+	revs := len(page.Revisions)
+
+	common := []string{"-wiki", server.APIURL(), "-title", title, "-dump", "dump"}
+	apiWiki, err := history.ParseWiki(server.APIURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumpDir := scraper.DumpDir("dump", apiWiki, title)
+
+	runOK(t, append([]string{"scrape", "-rate", "100"}, common...)...)
+
+	if n := countDir(t, filepath.Join(dumpDir, "revs")); n != revs {
+		t.Errorf("revs/ has %d files, want %d", n, revs)
+	}
+	data, err := os.ReadFile(filepath.Join(dumpDir, "0ids.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []*scraper.RevisionMeta
+	if err = json.Unmarshal(data, &ids); err != nil {
+		t.Fatalf("0ids.json: %v", err)
+	}
+	if len(ids) != revs {
+		t.Fatalf("0ids.json has %d revisions, want %d", len(ids), revs)
+	}
+	// Newest first, down to the page creation
+	if ids[0].RevID != page.Revisions[revs-1].RevID || ids[revs-1].ParentID != 0 {
+		t.Errorf("0ids.json runs from %d to %d (parent %d)", ids[0].RevID, ids[revs-1].RevID, ids[revs-1].ParentID)
+	}
+	status, err := scraper.ReadDumpStatus(dumpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.IndexComplete || status.OnDisk != revs {
+		t.Errorf("status: complete %t, %d on disk", status.IndexComplete, status.OnDisk)
+	}
+
+	runOK(t, append([]string{"process", "-rate", "100", "-workers", "4"}, common...)...)
+
+	analysed, err := preprocessor.CountAnalysed(filepath.Join(dumpDir, "0analysis.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if analysed != revs {
+		t.Errorf("0analysis.jsonl has %d revisions, want %d", analysed, revs)
+	}
+	if n := countDir(t, filepath.Join(dumpDir, "clean")); n != revs {
+		t.Errorf("clean/ has %d files, want %d", n, revs)
+	}
+	if _, err = os.Stat(filepath.Join(scraper.WikiDumpDir("dump", apiWiki), preprocessor.UserStoreFile)); err != nil {
+		t.Errorf("user store: %v", err)
+	}
+
+	runOK(t, append([]string{"compress"}, common...)...)
+
+	compressed, err := os.ReadFile(filepath.Join(dumpDir, "compress.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) == 0 {
+		t.Error("compress.txt is empty")
+	}
+}
+
+// The same dump in one go, with the scraped revisions streamed to the preprocessor
+func TestAllStream(t *testing.T) {
+	const title = "Deep learning"
+	wiki, server := fakeWiki(t)
+	revs := len(fakePage(t, wiki, title).Revisions)
+
+	runOK(t, "all", "-stream", "-rate", "100", "-workers", "4", "-wiki", server.APIURL(), "-title", title, "-dump", "dump")
+
+	apiWiki, err := history.ParseWiki(server.APIURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumpDir := scraper.DumpDir("dump", apiWiki, title)
+	if n := countDir(t, filepath.Join(dumpDir, "revs")); n != revs {
+		t.Errorf("revs/ has %d files, want %d", n, revs)
+	}
+	analysed, err := preprocessor.CountAnalysed(filepath.Join(dumpDir, "0analysis.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if analysed != revs {
+		t.Errorf("0analysis.jsonl has %d revisions, want %d", analysed, revs)
+	}
+	if _, err = os.Stat(filepath.Join(dumpDir, "compress.txt")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"evolve/debugger"
+	"evolve/wikipedia/history"
+	"evolve/wikipedia/history/preprocessor"
+	"evolve/wikipedia/history/scraper"
+	"fmt"
+)
+
+// Scrapes one title and processes its revisions as they're saved, without waiting for the whole history
+func stream(cfg *config, title string, client *history.Client, debugger *debugger.Debugger) error {
+	scrapeOpts, err := cfg.scrapeOptions()
+	if err != nil {
+		return err
+	}
+	// Small, so a slow preprocessor holds the scraper back instead of piling revisions up in memory
+	revChan := make(chan *scraper.Revision, 20)
+	scrapeOpts.Out = revChan
+
+	sc, err := scraper.NewWikiScrape(title, cfg.dumpRoot, scrapeOpts, client, debugger)
+	if err != nil {
+		return err
+	}
+	metaChan := make(chan *preprocessor.RevisionMeta, 20)
+	dumpDir := scraper.DumpDir(cfg.dumpRoot, client.Wiki(), title)
+	pre, err := preprocessor.NewWikiPreprocessor("", metaChan, dumpDir, cfg.processOptions(), client, debugger)
+	if err != nil {
+		return err
+	}
+
+	if err := pre.Run(); err != nil {
+		return err
+	}
+	if err := sc.Run(); err != nil {
+		pre.Stop()
+		return err
+	}
+
+	p := newPipeline(sc, pre, revChan, metaChan)
+	err = await("pipeline", p)
+	sc.PrintMetrics()
+	pre.PrintMetrics()
+	return err
+}
+
+// The scraper and preprocessor of a title run as one stage
+type pipeline struct {
+	scraper      stage
+	preprocessor stage
+
+	done chan struct{}
+	err  error
+}
+
+func newPipeline(sc, pre stage, revChan <-chan *scraper.Revision, metaChan chan<- *preprocessor.RevisionMeta) *pipeline {
+	p := &pipeline{
+		scraper:      sc,
+		preprocessor: pre,
+		done:         make(chan struct{}),
+	}
+
+	// Hands the revisions over, once the preprocessor is gone they're only drained so the scraper can finish
+	go func() {
+		defer close(metaChan)
+		for rev := range revChan {
+			select {
+			case <-pre.Done():
+			case metaChan <- toPreprocessorMeta(rev):
+			}
+		}
+	}()
+
+	// A preprocessor that failed has no use for more revisions
+	go func() {
+		<-pre.Done()
+		sc.Stop()
+	}()
+
+	go func() {
+		scrapeErr := sc.Wait()
+		processErr := pre.Wait()
+		if scrapeErr != nil {
+			scrapeErr = fmt.Errorf("scrape: %w", scrapeErr)
+		}
+		if processErr != nil {
+			processErr = fmt.Errorf("process: %w", processErr)
+		}
+		p.err = errors.Join(scrapeErr, processErr)
+		close(p.done)
+	}()
+
+	return p
+}
+
+func (s *pipeline) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *pipeline) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Stops both, whatever was saved so far stays on disk
+func (s *pipeline) Stop() error {
+	s.scraper.Stop()
+	s.preprocessor.Stop()
+
+	return s.Wait()
+}
+
+func toPreprocessorMeta(rev *scraper.Revision) *preprocessor.RevisionMeta {
+	meta := &preprocessor.RevisionMeta{
+		RevID:      rev.Meta.RevID,
+		ParentID:   rev.Meta.ParentID,
+		TimeStamp:  rev.Meta.TimeStamp,
+		Size:       rev.Meta.Size,
+		User:       rev.Meta.User,
+		UserID:     rev.Meta.UserID,
+		Comment:    rev.Meta.Comment,
+		Anon:       rev.Meta.Anon,
+		Temp:       rev.Meta.Temp,
+		UserHidden: rev.Meta.UserHidden,
+		SHA1:       rev.Meta.SHA1,
+		Tags:       rev.Meta.Tags,
+	}
+	if rev.Content != nil {
+		meta.Content = &preprocessor.RevisionContent{
+			RevID:     rev.Content.RevID,
+			ParentID:  rev.Content.ParentID,
+			TimeStamp: rev.Content.TimeStamp,
+			Slots: preprocessor.RevisionContentSlots{
+				Main: preprocessor.RevisionContentSlotsMain(rev.Content.Slots.Main),
+			},
+			User:    rev.Content.User,
+			Comment: rev.Content.Comment,
+		}
+	}
+	return meta
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"evolve/wikipedia/history/preprocessor"
+	"evolve/wikipedia/history/scraper"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+func runStatus(cfg *config) error {
+	titles, err := cfg.collectTitles(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+
+	wiki, err := cfg.wiki()
+	if err != nil {
+		return err
+	}
+
+	for _, title := range titles {
+		dumpDir := scraper.DumpDir(cfg.dumpRoot, wiki, title)
+		if _, err := os.Stat(dumpDir); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				fmt.Printf("%s: not scraped\n", title)
+				continue
+			}
+			return err
+		}
+
+		status, err := scraper.ReadDumpStatus(dumpDir)
+		if err != nil {
+			return fmt.Errorf("%s: %w", title, err)
+		}
+		cleaned, err := countFiles(filepath.Join(dumpDir, "clean"))
+		if err != nil {
+			return fmt.Errorf("%s: %w", title, err)
+		}
+
+		fmt.Printf("%s:\n", title)
+		fmt.Printf("  indexed:    %d (complete: %t)\n", status.Indexed, status.IndexComplete)
+		fmt.Printf("  revs:       %d\n", status.OnDisk)
+		if status.Checkpoint != nil {
+			fmt.Printf("  checkpoint: %d-%d\n", status.Checkpoint.RevID, status.Checkpoint.ParentID)
+		}
+		fmt.Printf("  cleaned:    %d\n", cleaned)
+		analysed, err := preprocessor.CountAnalysed(filepath.Join(dumpDir, "0analysis.jsonl"))
+		if err != nil {
+			return fmt.Errorf("%s: %w", title, err)
+		}
+		fmt.Printf("  analysed:   %d\n", analysed)
+		fmt.Printf("  compressed: %t\n", exists(filepath.Join(dumpDir, "compress.txt")))
+	}
+	return nil
+}
+
+func countFiles(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	count := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func exists(fPath string) bool {
+	_, err := os.Stat(fPath)
+	return err == nil
+}
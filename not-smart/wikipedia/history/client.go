@@ -0,0 +1,242 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"evolve/debugger"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Client talks to the MediaWiki API, retrying transient failures:
+// transport errors, 429/5xx, and the maxlag/ratelimited API errors.
+// One Client is meant to be shared process-wide, so every stage obeys the same rate limit.
+type Client struct {
+	wiki       *Wiki
+	rootURL    *url.URL
+	httpClient *http.Client
+	userAgent  string
+	limiter    *Limiter
+
+	// Seconds of replication lag after which the API should refuse us, 0 to not send maxlag
+	MaxLag int
+	// Attempts after the first one
+	MaxRetries int
+	// Backoff doubles from MinBackoff on every retry, capped at MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	debugger *debugger.Debugger
+}
+
+// rate is the max number of requests in 1 second
+func NewClient(wiki *Wiki, rate int, debugger *debugger.Debugger) (*Client, error) {
+	u, err := url.Parse(wiki.APIURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		wiki:    wiki,
+		rootURL: u,
+		httpClient: &http.Client{
+			Timeout: 60 * time.Second,
+		},
+		userAgent:  USER_AGENT,
+		limiter:    NewLimiter(rate),
+		MaxLag:     5,
+		MaxRetries: 6,
+		MinBackoff: 500 * time.Millisecond,
+		MaxBackoff: 60 * time.Second,
+		debugger:   debugger,
+	}, nil
+}
+
+// The wiki every query goes to
+func (s *Client) Wiki() *Wiki {
+	return s.wiki
+}
+
+// Swaps how requests reach the wiki, e.g. for recording or replaying them
+func (s *Client) SetTransport(rt http.RoundTripper) {
+	s.httpClient.Transport = rt
+}
+
+type apiEnvelope struct {
+	Error    *APIError    `json:"error"`
+	Continue Continuation `json:"continue"`
+}
+
+// URL of the query, continuing from cont if it isn't nil
+func (s *Client) URL(q Query, cont Continuation) string {
+	params := q.Params()
+	for key, val := range cont {
+		params.Set(key, val)
+	}
+	if s.MaxLag > 0 {
+		params.Set("maxlag", strconv.Itoa(s.MaxLag))
+	}
+
+	u := *s.rootURL
+	u.RawQuery = params.Encode()
+
+	return u.String()
+}
+
+// Query runs a single request and decodes the JSON response into out.
+// Returns the continuation of the next page, nil on the last one.
+// API level errors are returned as *APIError, HTTP ones as *StatusError.
+func (s *Client) Query(ctx context.Context, q Query, cont Continuation, out any) (Continuation, error) {
+	return s.get(ctx, s.URL(q, cont), out)
+}
+
+// Paginate calls fn with every page of the query, following the "continue" blocks.
+// next is the continuation after the page, nil on the last one.
+func Paginate[T any](ctx context.Context, c *Client, q Query, cont Continuation, fn func(page *T, next Continuation) error) error {
+	for {
+		page := new(T)
+		next, err := c.Query(ctx, q, cont, page)
+		if err != nil {
+			return err
+		}
+		if err = fn(page, next); err != nil {
+			return err
+		}
+		if next == nil {
+			return nil
+		}
+		cont = next
+	}
+}
+
+func (s *Client) get(ctx context.Context, reqURL string, out any) (Continuation, error) {
+	var lastErr error
+	for attempt := 0; attempt <= s.MaxRetries; attempt++ {
+		if err := s.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		envelope, body, wait, err := s.do(ctx, reqURL)
+		if err == nil {
+			if err = json.Unmarshal(body, out); err != nil {
+				return nil, newDecodeError(reqURL, body, err)
+			}
+			return envelope.Continue, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !retryable(err) {
+			return nil, err
+		}
+		lastErr = err
+		if attempt == s.MaxRetries {
+			break
+		}
+
+		if wait == 0 {
+			wait = s.backoff(attempt)
+		}
+		// A bad Retry-After could ask for hours
+		wait = min(wait, s.MaxBackoff)
+		s.debugger.Debug(fmt.Sprintf("retrying %s in %s (attempt %d): %v", reqURL, wait, attempt+1, err))
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s: %w", ErrRetriesExhausted, reqURL, lastErr)
+}
+
+// Does a single request, returns the body of a successful response,
+// or the error along with how long the server asked us to wait.
+func (s *Client) do(ctx context.Context, reqURL string) (*apiEnvelope, []byte, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	req.Header.Set("User-Agent", s.userAgent)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	wait := retryAfter(resp.Header.Get("Retry-After"))
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, nil, wait, &StatusError{StatusCode: resp.StatusCode, URL: reqURL}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, wait, err
+	}
+
+	envelope := new(apiEnvelope)
+	if err = json.Unmarshal(body, envelope); err != nil {
+		return nil, nil, 0, newDecodeError(reqURL, body, err)
+	}
+	if envelope.Error != nil {
+		return nil, nil, wait, envelope.Error
+	}
+
+	return envelope, body, 0, nil
+}
+
+// Exponential backoff with jitter, between half and the full step
+func (s *Client) backoff(attempt int) time.Duration {
+	step := s.MinBackoff << attempt
+	if step <= 0 || step > s.MaxBackoff {
+		step = s.MaxBackoff
+	}
+	half := step / 2
+	return half + rand.N(half+1)
+}
+
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return retryableCodes[apiErr.Code]
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		return false
+	}
+	// Transport errors
+	return true
+}
+
+// Retry-After is either a number of seconds or an HTTP date
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
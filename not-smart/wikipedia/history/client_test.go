@@ -0,0 +1,78 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"evolve/debugger"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A client of a server that always answers 503 with the Retry-After header
+func unavailableClient(t *testing.T, retryAfter string) (*Client, *atomic.Int64) {
+	t.Helper()
+
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Retry-After", retryAfter)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	t.Chdir(t.TempDir())
+	debugger, err := debugger.NewDebugger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki, err := ParseWiki(server.URL + "/w/api.php")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(wiki, 0, debugger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, &hits
+}
+
+func queryUnavailable(t *testing.T, client *Client) time.Duration {
+	t.Helper()
+
+	start := time.Now()
+	_, err := client.Query(context.Background(), &TitlesQuery{Titles: []string{"X"}}, nil, new(struct{}))
+	if !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("err %v, want %v", err, ErrRetriesExhausted)
+	}
+	return time.Since(start)
+}
+
+// Retry-After is capped at MaxBackoff
+func TestClientClampsRetryAfter(t *testing.T) {
+	client, hits := unavailableClient(t, "3600")
+	client.MaxRetries = 2
+	client.MaxBackoff = 20 * time.Millisecond
+
+	if took := queryUnavailable(t, client); took > 5*time.Second {
+		t.Errorf("took %s", took)
+	}
+	if n := hits.Load(); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+}
+
+// No wait after the last attempt, there's nothing left to wait for
+func TestClientNoWaitAfterLastAttempt(t *testing.T) {
+	client, hits := unavailableClient(t, "10")
+	client.MaxRetries = 0
+
+	if took := queryUnavailable(t, client); took > 5*time.Second {
+		t.Errorf("took %s", took)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("%d requests, want 1", n)
+	}
+}
@@ -1,6 +1,7 @@
 package compressor
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -9,6 +10,12 @@ import (
 
 type Compressor struct {
 	rootDir string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Closed once the compression returned, err is its error
+	done chan struct{}
+	err  error
 }
 
 func NewCompressor(rootDir string) *Compressor {
@@ -17,7 +24,38 @@ func NewCompressor(rootDir string) *Compressor {
 	}
 }
 
+// Run starts compressing in the background
 func (s *Compressor) Run() error {
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.done = make(chan struct{})
+
+	go func() {
+		s.err = s.compress()
+		close(s.done)
+	}()
+
+	return nil
+}
+
+// Done is closed once compress.txt is written, or the run was stopped
+func (s *Compressor) Done() <-chan struct{} {
+	return s.done
+}
+
+// Wait blocks until the run is over and returns its error
+func (s *Compressor) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Stop cancels the run early, compress.txt is left incomplete
+func (s *Compressor) Stop() error {
+	s.cancel()
+
+	return s.Wait()
+}
+
+func (s *Compressor) compress() error {
 	cleanDir := filepath.Join(s.rootDir, "clean")
 	entries, err := os.ReadDir(cleanDir)
 	if err != nil {
@@ -30,8 +68,12 @@ func (s *Compressor) Run() error {
 	if err != nil {
 		return err
 	}
+	defer outF.Close()
 
 	for _, entry := range entries {
+		if s.ctx.Err() != nil {
+			return nil
+		}
 		if entry.IsDir() {
 			continue
 		}
@@ -58,7 +100,6 @@ func (s *Compressor) Run() error {
 		}
 	}
 
-	outF.Close()
 	fmt.Println("COMPRESS DONE")
 
 	return nil
@@ -0,0 +1,72 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The "error" object of a MediaWiki API response
+type APIError struct {
+	Code          string `json:"code"`
+	Info          string `json:"info"`
+	DebugWildcard string `json:"*"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("mediawiki api error %s: %s", e.Code, e.Info)
+}
+
+// A non-200 HTTP response
+type StatusError struct {
+	StatusCode int
+	URL        string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %d from %s", e.StatusCode, e.URL)
+}
+
+// A 200 response whose body isn't the JSON expected, e.g. an HTML error page from a proxy.
+// Not retried, the same request gets the same page.
+type DecodeError struct {
+	URL string
+	// The start of the body, at most decodeErrorBodyLen bytes
+	Body string
+	Err  error
+}
+
+const decodeErrorBodyLen = 200
+
+func newDecodeError(reqURL string, body []byte, err error) *DecodeError {
+	if len(body) > decodeErrorBodyLen {
+		body = body[:decodeErrorBodyLen]
+	}
+	return &DecodeError{URL: reqURL, Body: string(body), Err: err}
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("undecodable response from %s: %v: %q", e.URL, e.Err, e.Body)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Returned once every retry of a request failed, wraps the last error
+var ErrRetriesExhausted = errors.New("retries exhausted")
+
+// API error codes that go away if we wait and ask again
+var retryableCodes = map[string]bool{
+	"maxlag":                          true,
+	"ratelimited":                     true,
+	"readonly":                        true,
+	"internal_api_error_DBQueryError": true,
+}
+
+func IsAPIError(err error, code string) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == code
+	}
+	return false
+}
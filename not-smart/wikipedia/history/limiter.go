@@ -0,0 +1,53 @@
+package history
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter spaces requests evenly, it is shared by everything using the same Client
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// Max number of requests in 1 second, 0 for no limit
+func NewLimiter(rate int) *Limiter {
+	l := new(Limiter)
+	if rate > 0 {
+		l.interval = time.Second / time.Duration(rate)
+	}
+	return l
+}
+
+// Wait blocks until the caller's turn, or the context is done
+func (s *Limiter) Wait(ctx context.Context) error {
+	if s.interval == 0 {
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	at := s.next
+	if at.Before(now) {
+		at = now
+	}
+	s.next = at.Add(s.interval)
+	s.mu.Unlock()
+
+	wait := time.Until(at)
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
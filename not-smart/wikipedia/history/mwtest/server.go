@@ -0,0 +1,570 @@
+// Package mwtest is an in-process stand-in for the MediaWiki API,
+// serving the parts of action=query the scraper and preprocessor use.
+package mwtest
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Revision struct {
+	RevID     int       `json:"revid"`
+	ParentID  int       `json:"parentid"`
+	TimeStamp time.Time `json:"timestamp"`
+	User      string    `json:"user"`
+	UserID    int       `json:"userid"`
+	Comment   string    `json:"comment"`
+	Content   string    `json:"content"`
+	// Change tags, mw-reverted, mw-rollback...
+	Tags []string `json:"tags,omitempty"`
+	// Revision deleted user name, user and userid aren't returned
+	UserHidden bool `json:"userhidden,omitempty"`
+}
+
+type Page struct {
+	PageID    int    `json:"pageid"`
+	NameSpace int    `json:"ns"`
+	Title     string `json:"title"`
+	// Titles redirecting to this page
+	Redirects []string `json:"redirects"`
+	// Oldest first
+	Revisions []*Revision `json:"revisions"`
+}
+
+type User struct {
+	UserID       int       `json:"userid"`
+	Name         string    `json:"name"`
+	EditCount    int       `json:"editcount"`
+	Registration time.Time `json:"registration"`
+	Groups       []string  `json:"groups"`
+	// The rights log of the user, oldest first, only served by list=logevents
+	RightsLog []*RightsEvent `json:"rightsLog,omitempty"`
+}
+
+type RightsEvent struct {
+	TimeStamp time.Time `json:"timestamp"`
+	OldGroups []string  `json:"oldgroups"`
+	NewGroups []string  `json:"newgroups"`
+}
+
+type Category struct {
+	// With the "Category:" prefix
+	Title string `json:"title"`
+	// Article titles and sub categories, "Category:..."
+	Members []string `json:"members"`
+}
+
+// The content of the fake wiki
+type Wiki struct {
+	Pages      []*Page     `json:"pages"`
+	Users      []*User     `json:"users"`
+	Categories []*Category `json:"categories"`
+}
+
+func LoadWiki(fPath string) (*Wiki, error) {
+	data, err := os.ReadFile(fPath)
+	if err != nil {
+		return nil, err
+	}
+	wiki := new(Wiki)
+	if err = json.Unmarshal(data, wiki); err != nil {
+		return nil, err
+	}
+	return wiki, nil
+}
+
+type Server struct {
+	*httptest.Server
+
+	wiki *Wiki
+
+	// Number of API requests served, failures included
+	Requests atomic.Int64
+
+	mu         sync.Mutex
+	failNext   int
+	failStatus int
+}
+
+func NewServer(wiki *Wiki) *Server {
+	s := &Server{wiki: wiki}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/w/api.php", s.handle)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// The api.php URL to point a client at
+func (s *Server) APIURL() string {
+	return s.Server.URL + "/w/api.php"
+}
+
+// FailNext makes the next n requests fail with status, asking for a 1s Retry-After
+func (s *Server) FailNext(n, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failNext = n
+	s.failStatus = status
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.Requests.Add(1)
+
+	s.mu.Lock()
+	if s.failNext > 0 {
+		s.failNext--
+		status := s.failStatus
+		s.mu.Unlock()
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(status)
+		return
+	}
+	s.mu.Unlock()
+
+	params := r.URL.Query()
+	if params.Get("action") != "query" {
+		writeError(w, "badvalue", "Unrecognized value for parameter \"action\"")
+		return
+	}
+
+	var resp any
+	var err error
+	switch {
+	case params.Get("list") == "users":
+		resp, err = s.users(params.Get("ususerids"))
+	case params.Get("list") == "logevents":
+		resp, err = s.logEvents(params)
+	case params.Get("list") == "categorymembers":
+		resp, err = s.categoryMembers(params)
+	case params.Get("prop") == "revisions" && params.Get("revids") != "":
+		resp, err = s.revisionsByID(params)
+	case params.Get("prop") == "revisions" && params.Get("pageids") != "":
+		resp, err = s.history(params)
+	case params.Get("titles") != "":
+		resp = s.resolve(params.Get("titles"))
+	default:
+		err = fmt.Errorf("unsupported query: %s", r.URL.RawQuery)
+	}
+	if err != nil {
+		writeError(w, "badvalue", err.Error())
+		return
+	}
+
+	writeJSON(w, resp)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code, info string) {
+	writeJSON(w, map[string]any{
+		"error": map[string]string{
+			"code": code,
+			"info": info,
+		},
+	})
+}
+
+func splitInts(str string) ([]int, error) {
+	ids := make([]int, 0)
+	for _, part := range strings.Split(str, "|") {
+		if part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *Server) pageByTitle(title string) (*Page, string) {
+	for _, page := range s.wiki.Pages {
+		if page.Title == title {
+			return page, ""
+		}
+		if slices.Contains(page.Redirects, title) {
+			return page, title
+		}
+	}
+	return nil, ""
+}
+
+func (s *Server) pageByID(id int) *Page {
+	for _, page := range s.wiki.Pages {
+		if page.PageID == id {
+			return page
+		}
+	}
+	return nil
+}
+
+// >>>>>
+
+func (s *Server) resolve(titles string) any {
+	redirects := make([]map[string]string, 0)
+	pages := make([]map[string]any, 0)
+
+	for _, title := range strings.Split(titles, "|") {
+		page, from := s.pageByTitle(title)
+		if page == nil {
+			pages = append(pages, map[string]any{"ns": 0, "title": title, "missing": true})
+			continue
+		}
+		if from != "" {
+			redirects = append(redirects, map[string]string{"from": from, "to": page.Title})
+		}
+		pages = append(pages, map[string]any{"pageid": page.PageID, "ns": page.NameSpace, "title": page.Title})
+	}
+
+	query := map[string]any{"pages": pages}
+	if len(redirects) > 0 {
+		query["redirects"] = redirects
+	}
+	return map[string]any{
+		"batchcomplete": true,
+		"query":         query,
+	}
+}
+
+// >>>>>
+
+func revisionJSON(rev *Revision, props []string) map[string]any {
+	out := make(map[string]any)
+	for _, prop := range props {
+		switch prop {
+		case "ids":
+			out["revid"] = rev.RevID
+			out["parentid"] = rev.ParentID
+		case "timestamp":
+			out["timestamp"] = rev.TimeStamp.UTC().Format(time.RFC3339)
+		case "size":
+			out["size"] = len(rev.Content)
+		case "user":
+			if rev.UserHidden {
+				out["userhidden"] = true
+				continue
+			}
+			out["user"] = rev.User
+			// Logged out, the user name is the address
+			if rev.UserID == 0 {
+				out["anon"] = true
+			}
+		case "userid":
+			if !rev.UserHidden {
+				out["userid"] = rev.UserID
+			}
+		case "comment":
+			out["comment"] = rev.Comment
+		case "sha1":
+			out["sha1"] = fmt.Sprintf("%x", sha1.Sum([]byte(rev.Content)))
+		case "tags":
+			out["tags"] = append([]string{}, rev.Tags...)
+		case "content":
+			out["slots"] = map[string]any{
+				"main": map[string]any{
+					"contentmodel":  "wikitext",
+					"contentformat": "text/x-wiki",
+					"content":       rev.Content,
+				},
+			}
+		}
+	}
+	return out
+}
+
+func pageJSON(page *Page, revisions []map[string]any) map[string]any {
+	return map[string]any{
+		"pageid":    page.PageID,
+		"ns":        page.NameSpace,
+		"title":     page.Title,
+		"revisions": revisions,
+	}
+}
+
+// prop=revisions&pageids=, newest first unless rvdir=newer, continued with rvcontinue=<timestamp>|<revid>
+func (s *Server) history(params url.Values) (any, error) {
+	pageID, err := strconv.Atoi(params.Get("pageids"))
+	if err != nil {
+		return nil, err
+	}
+	page := s.pageByID(pageID)
+	if page == nil {
+		return nil, fmt.Errorf("no page with id %d", pageID)
+	}
+
+	limit := 50
+	if l := params.Get("rvlimit"); l == "max" {
+		limit = 500
+	} else if l != "" {
+		if limit, err = strconv.Atoi(l); err != nil {
+			return nil, err
+		}
+	}
+	props := strings.Split(params.Get("rvprop"), "|")
+	newer := params.Get("rvdir") == "newer"
+
+	revisions := slices.Clone(page.Revisions)
+	if !newer {
+		slices.Reverse(revisions)
+	}
+
+	// Where the listing starts, rvcontinue wins over rvstartid and rvstart
+	startIdx := 0
+	if cont := params.Get("rvcontinue"); cont != "" {
+		_, idStr, _ := strings.Cut(cont, "|")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			return nil, fmt.Errorf("bad rvcontinue %q", cont)
+		}
+		startIdx = slices.IndexFunc(revisions, func(r *Revision) bool { return r.RevID == id })
+	} else if startID := params.Get("rvstartid"); startID != "" {
+		id, err := strconv.Atoi(startID)
+		if err != nil {
+			return nil, err
+		}
+		startIdx = slices.IndexFunc(revisions, func(r *Revision) bool { return r.RevID == id })
+	}
+	if startIdx < 0 {
+		return nil, fmt.Errorf("start revision not found")
+	}
+
+	// rvstart/rvend bound the timestamps in the direction of the listing
+	var start, end time.Time
+	if str := params.Get("rvstart"); str != "" {
+		if start, err = time.Parse(time.RFC3339, str); err != nil {
+			return nil, err
+		}
+	}
+	if str := params.Get("rvend"); str != "" {
+		if end, err = time.Parse(time.RFC3339, str); err != nil {
+			return nil, err
+		}
+	}
+	inWindow := func(r *Revision) bool {
+		if newer {
+			return (start.IsZero() || !r.TimeStamp.Before(start)) && (end.IsZero() || !r.TimeStamp.After(end))
+		}
+		return (start.IsZero() || !r.TimeStamp.After(start)) && (end.IsZero() || !r.TimeStamp.Before(end))
+	}
+
+	out := make([]map[string]any, 0)
+	var next *Revision
+	for _, rev := range revisions[startIdx:] {
+		if !inWindow(rev) {
+			continue
+		}
+		if len(out) == limit {
+			next = rev
+			break
+		}
+		out = append(out, revisionJSON(rev, props))
+	}
+
+	resp := map[string]any{
+		"query": map[string]any{
+			"pages": []any{pageJSON(page, out)},
+		},
+	}
+	if next != nil {
+		resp["continue"] = map[string]string{
+			"rvcontinue": fmt.Sprintf("%s|%d", next.TimeStamp.UTC().Format("20060102150405"), next.RevID),
+			"continue":   "||",
+		}
+	} else {
+		resp["batchcomplete"] = true
+	}
+	return resp, nil
+}
+
+// prop=revisions&revids=, the revisions of the first page they belong to
+func (s *Server) revisionsByID(params url.Values) (any, error) {
+	ids, err := splitInts(params.Get("revids"))
+	if err != nil {
+		return nil, err
+	}
+	props := strings.Split(params.Get("rvprop"), "|")
+
+	pages := make([]any, 0)
+	for _, page := range s.wiki.Pages {
+		found := make([]*Revision, 0)
+		for _, rev := range page.Revisions {
+			if slices.Contains(ids, rev.RevID) {
+				found = append(found, rev)
+			}
+		}
+		if len(found) == 0 {
+			continue
+		}
+		// Same order the ids' were asked in, like the API
+		slices.SortStableFunc(found, func(a, b *Revision) int {
+			return slices.Index(ids, a.RevID) - slices.Index(ids, b.RevID)
+		})
+		out := make([]map[string]any, 0, len(found))
+		for _, rev := range found {
+			out = append(out, revisionJSON(rev, props))
+		}
+		pages = append(pages, pageJSON(page, out))
+	}
+
+	return map[string]any{
+		"batchcomplete": true,
+		"query": map[string]any{
+			"pages": pages,
+		},
+	}, nil
+}
+
+// >>>>>
+
+func (s *Server) users(userIDs string) (any, error) {
+	ids, err := splitInts(userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]any, 0)
+	for _, id := range ids {
+		idx := slices.IndexFunc(s.wiki.Users, func(u *User) bool { return u.UserID == id })
+		if idx < 0 {
+			users = append(users, map[string]any{"userid": id, "missing": true})
+			continue
+		}
+		user := s.wiki.Users[idx]
+		users = append(users, map[string]any{
+			"userid":       user.UserID,
+			"name":         user.Name,
+			"editcount":    user.EditCount,
+			"registration": user.Registration.UTC().Format(time.RFC3339),
+			"groups":       user.Groups,
+		})
+	}
+
+	return map[string]any{
+		"batchcomplete": true,
+		"query": map[string]any{
+			"users": users,
+		},
+	}, nil
+}
+
+// >>>>>
+
+// list=logevents, only the rights log of a letitle=User:<name>, in one page
+func (s *Server) logEvents(params url.Values) (any, error) {
+	if params.Get("letype") != "rights" {
+		return nil, fmt.Errorf("only letype=rights is supported")
+	}
+	title := params.Get("letitle")
+	name, ok := strings.CutPrefix(title, "User:")
+	if !ok {
+		return nil, fmt.Errorf("letitle has to be a User: page, got %q", title)
+	}
+
+	events := make([]any, 0)
+	idx := slices.IndexFunc(s.wiki.Users, func(u *User) bool { return u.Name == name })
+	if idx >= 0 {
+		for i, event := range s.wiki.Users[idx].RightsLog {
+			metadata := make([]any, 0, len(event.NewGroups))
+			for _, group := range event.NewGroups {
+				metadata = append(metadata, map[string]string{"group": group, "expiry": "infinity"})
+			}
+			events = append(events, map[string]any{
+				"logid":     s.wiki.Users[idx].UserID*1000 + i,
+				"ns":        2,
+				"title":     title,
+				"type":      "rights",
+				"action":    "rights",
+				"timestamp": event.TimeStamp.UTC().Format(time.RFC3339),
+				"params": map[string]any{
+					"oldgroups":   event.OldGroups,
+					"newgroups":   event.NewGroups,
+					"newmetadata": metadata,
+				},
+			})
+		}
+	}
+	// Newest first unless ledir=newer, like the API
+	if params.Get("ledir") != "newer" {
+		slices.Reverse(events)
+	}
+
+	return map[string]any{
+		"batchcomplete": true,
+		"query": map[string]any{
+			"logevents": events,
+		},
+	}, nil
+}
+
+// >>>>>
+
+// list=categorymembers, continued with cmcontinue=page|<offset>
+func (s *Server) categoryMembers(params url.Values) (any, error) {
+	title := params.Get("cmtitle")
+	idx := slices.IndexFunc(s.wiki.Categories, func(c *Category) bool { return c.Title == title })
+	if idx < 0 {
+		return nil, fmt.Errorf("no category %q", title)
+	}
+	category := s.wiki.Categories[idx]
+
+	limit := 10
+	if l := params.Get("cmlimit"); l == "max" {
+		limit = 500
+	} else if l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil {
+			return nil, err
+		}
+	}
+	offset := 0
+	if cont := params.Get("cmcontinue"); cont != "" {
+		_, offStr, _ := strings.Cut(cont, "|")
+		var err error
+		if offset, err = strconv.Atoi(offStr); err != nil {
+			return nil, fmt.Errorf("bad cmcontinue %q", cont)
+		}
+	}
+
+	members := make([]any, 0)
+	end := min(offset+limit, len(category.Members))
+	for _, title := range category.Members[offset:end] {
+		member := map[string]any{"ns": 0, "title": title}
+		if strings.HasPrefix(title, "Category:") {
+			member["ns"] = 14
+		} else if page, _ := s.pageByTitle(title); page != nil {
+			member["pageid"] = page.PageID
+		}
+		members = append(members, member)
+	}
+
+	resp := map[string]any{
+		"query": map[string]any{
+			"categorymembers": members,
+		},
+	}
+	if end < len(category.Members) {
+		resp["continue"] = map[string]string{
+			"cmcontinue": fmt.Sprintf("page|%d", end),
+			"continue":   "-||",
+		}
+	} else {
+		resp["batchcomplete"] = true
+	}
+	return resp, nil
+}
@@ -0,0 +1,176 @@
+package preprocessor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// Appends to 0analysis.jsonl, one RevisionAnalysis per line in the order they finish.
+// Every line goes out in a single write, so a crash can only cut the last one short,
+// and that's trimmed the next time the file is opened. A revision can have several lines,
+// the last one wins.
+type AnalysisWriter struct {
+	mu sync.Mutex
+	f  *os.File
+	// Revisions already analysed without an error, true once no revert can tag them anymore.
+	// Failed and pending ones are analysed again, see RevisionTags.RevertsPending.
+	done map[int]bool
+}
+
+func OpenAnalysisWriter(fPath string) (*AnalysisWriter, error) {
+	done, size, err := readAnalysed(fPath)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(fPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// Drop a half written last line
+	if err = f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err = f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &AnalysisWriter{
+		f:    f,
+		done: done,
+	}, nil
+}
+
+// The revision was analysed by a previous run, and no revert can tag it anymore
+func (s *AnalysisWriter) Has(revID int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.done[revID]
+}
+
+func (s *AnalysisWriter) Write(r *RevisionAnalysis) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err = s.f.Write(line); err != nil {
+		return err
+	}
+	if len(r.Debug.Errors) == 0 {
+		s.done[r.Process.Meta.RevID] = !r.Tags.RevertsPending
+	}
+	return nil
+}
+
+func (s *AnalysisWriter) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.f.Sync(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+// Only what's needed to know if a line is done
+type analysedLine struct {
+	Process struct {
+		Meta struct {
+			RevID int `json:"revid"`
+		} `json:"meta"`
+	} `json:"process"`
+	Tags struct {
+		RevertsPending bool `json:"revertsPending"`
+	} `json:"tags"`
+	Debug struct {
+		Errors []string `json:"errors"`
+	} `json:"debug"`
+}
+
+// The revisions analysed without an error, see AnalysisWriter.done, and the size of the complete lines
+func readAnalysed(fPath string) (map[int]bool, int64, error) {
+	done := make(map[int]bool)
+
+	f, err := os.Open(fPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return done, 0, nil
+		}
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var size int64
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err != nil {
+			// Whatever is left without a newline is a cut off line
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, 0, err
+		}
+		size += int64(len(line))
+
+		parsed := new(analysedLine)
+		if err := json.Unmarshal(bytes.TrimSpace(line), parsed); err != nil {
+			continue
+		}
+		if len(parsed.Debug.Errors) == 0 {
+			done[parsed.Process.Meta.RevID] = !parsed.Tags.RevertsPending
+		} else {
+			delete(done, parsed.Process.Meta.RevID)
+		}
+	}
+
+	return done, size, nil
+}
+
+// Number of revisions analysed without an error in the file, pending ones included
+func CountAnalysed(fPath string) (int, error) {
+	done, _, err := readAnalysed(fPath)
+	if err != nil {
+		return 0, err
+	}
+	return len(done), nil
+}
+
+// >>>>>
+
+// errors marshal to {}, the messages are what's useful
+func (s *RevisionDebug) MarshalJSON() ([]byte, error) {
+	msgs := func(errs []error) []string {
+		if errs == nil {
+			return nil
+		}
+		strs := make([]string, 0, len(errs))
+		for _, err := range errs {
+			strs = append(strs, err.Error())
+		}
+		return strs
+	}
+
+	return json.Marshal(struct {
+		Errors   []string `json:"errors"`
+		Warnings []string `json:"warnings"`
+	}{
+		Errors:   msgs(s.Errors),
+		Warnings: msgs(s.Warnings),
+	})
+}
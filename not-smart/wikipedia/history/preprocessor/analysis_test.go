@@ -0,0 +1,55 @@
+package preprocessor
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func analysed(revID int, pending bool, errs ...error) *RevisionAnalysis {
+	return &RevisionAnalysis{
+		Process: &ProcessCtx{Meta: &RevisionMeta{RevID: revID}},
+		Tags:    &RevisionTags{RevertsPending: pending},
+		Debug:   &RevisionDebug{Errors: errs},
+	}
+}
+
+func TestAnalysisWriterReanalysesPending(t *testing.T) {
+	fPath := filepath.Join(t.TempDir(), "0analysis.jsonl")
+
+	analyses, err := OpenAnalysisWriter(fPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rc := range []*RevisionAnalysis{
+		analysed(1, false),
+		analysed(2, false, errors.New("failed")),
+		analysed(3, true),
+		// Pending in an earlier run, done in a later one
+		analysed(4, true),
+		analysed(4, false),
+	} {
+		if err = analyses.Write(rc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = analyses.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	analyses, err = OpenAnalysisWriter(fPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer analyses.Close()
+	for revID, want := range map[int]bool{1: true, 2: false, 3: false, 4: true} {
+		if got := analyses.Has(revID); got != want {
+			t.Errorf("Has(%d) = %t, want %t", revID, got, want)
+		}
+	}
+
+	// The pending one is analysed, it counts
+	if n, err := CountAnalysed(fPath); err != nil || n != 3 {
+		t.Errorf("CountAnalysed = %d, %v, want 3", n, err)
+	}
+}
@@ -0,0 +1,235 @@
+package preprocessor
+
+import (
+	"fmt"
+	"math/bits"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Thresholds of the cadence and repetition signals, an editor's edits are the ones on the article
+const (
+	// A burst is this many edits of an editor...
+	burstMinEdits = 4
+	// ...each at most this long after their previous one
+	burstMaxGap = 15 * time.Second
+
+	// Regular is this many gaps between an editor's last edits...
+	regularMinGaps = 8
+	// ...all within this fraction of their mean...
+	regularMaxSpread = 0.02
+	// ...and within this of it, someone editing every morning is minutes off, a scheduled job isn't
+	regularMaxJitter = time.Minute
+
+	// Repetitive is this many edits of an editor in a row with the same shape, when the summary
+	// looks made by a tool, a number masked in it or a link. Anyone can fix typos three times.
+	repetitiveMinEdits = 3
+	// ...or this many with any summary
+	repetitiveLongRun = 6
+)
+
+var (
+	// /* History */ at the start of a summary, the section edited
+	reSectionComment = regexp.MustCompile(`/\*.*?\*/`)
+	reDigits         = regexp.MustCompile(`\d+`)
+)
+
+// Tags automated edits, bot flag or not, from their summaries, change tags, how often the
+// editor edits and how alike their edits are. Revisions go through it oldest first.
+// The signals of an edit are the same tool seen several ways, they don't add up: each
+// dimension is reinforced once, by the strongest weight among them. That then reinforces
+// the group weights like any other factor, a flagged bot running a known tool ends up
+// above either alone, never past 100.
+type automationDetector struct {
+	rules *Rules
+	tools []*regexp.Regexp
+
+	// See editorKey
+	editors map[string]*editorHistory
+	// Page size after each revision, for the size changes
+	sizes map[int]int64
+}
+
+type automationSignal struct {
+	factor  string
+	weights map[string]int
+}
+
+type editorHistory struct {
+	// The last regularMinGaps+1 edits, oldest first
+	times  []time.Time
+	shapes []string
+}
+
+func newAutomationDetector(rules *Rules) (*automationDetector, error) {
+	tools := make([]*regexp.Regexp, len(rules.Tools))
+	for i, tool := range rules.Tools {
+		if tool.Comment == "" {
+			continue
+		}
+		re, err := regexp.Compile(tool.Comment)
+		if err != nil {
+			return nil, fmt.Errorf("tool %s: %v", tool.Name, err)
+		}
+		tools[i] = re
+	}
+	return &automationDetector{
+		rules:   rules,
+		tools:   tools,
+		editors: make(map[string]*editorHistory),
+		sizes:   make(map[int]int64),
+	}, nil
+}
+
+// editor is the editorKey of the revision's editor, empty ones only get the tool signals
+func (s *automationDetector) detect(rc *RevisionAnalysis, editor string) {
+	meta := rc.Process.Meta
+	tags := rc.Tags
+
+	signals := []*automationSignal{}
+	found := func(factor, evidence string, weights map[string]int) {
+		tags.IsAutomated = true
+		tags.AutomationEvidence = append(tags.AutomationEvidence, evidence)
+		signals = append(signals, &automationSignal{factor, weights})
+	}
+
+	for i, tool := range s.rules.Tools {
+		if s.tools[i] != nil && s.tools[i].MatchString(meta.Comment) {
+			found("tool:"+tool.Name, "comment: "+tool.Name, tool.Weights)
+			continue
+		}
+		if k := slices.IndexFunc(meta.Tags, func(tag string) bool { return slices.Contains(tool.Tags, tag) }); k >= 0 {
+			found("tool:"+tool.Name, "tag: "+meta.Tags[k], tool.Weights)
+		}
+	}
+
+	parentSize, sized := s.sizes[meta.ParentID]
+	if meta.ParentID == 0 {
+		parentSize, sized = 0, true
+	}
+	s.sizes[meta.RevID] = meta.Size
+
+	if editor == "" {
+		applyStrongest(rc, signals)
+		return
+	}
+	hist, ok := s.editors[editor]
+	if !ok {
+		hist = new(editorHistory)
+		s.editors[editor] = hist
+	}
+
+	hist.times = append(hist.times, meta.TimeStamp)
+	if len(hist.times) > regularMinGaps+1 {
+		hist.times = hist.times[1:]
+	}
+	if evidence := cadence(hist.times); evidence != "" {
+		found("cadence", evidence, s.rules.Cadence)
+	}
+
+	// An edit without a summary or a known parent breaks the row
+	shape := ""
+	skeleton := commentSkeleton(meta.Comment)
+	if skeleton != "" && sized {
+		shape = fmt.Sprintf("%q, %s bytes", skeleton, sizeBucket(meta.Size-parentSize))
+	}
+	hist.shapes = append(hist.shapes, shape)
+	if len(hist.shapes) > repetitiveLongRun {
+		hist.shapes = hist.shapes[1:]
+	}
+	run := repetitiveLongRun
+	if toolLikeSkeleton(skeleton) {
+		run = repetitiveMinEdits
+	}
+	if shape != "" && len(hist.shapes) >= run && allSame(hist.shapes[len(hist.shapes)-run:]) {
+		found("repetitive", fmt.Sprintf("repetitive: %d edits of %s", run, shape), s.rules.Repetitive)
+	}
+	applyStrongest(rc, signals)
+}
+
+// Reinforces each dimension by the strongest weight of the signals, under that signal's factor
+func applyStrongest(rc *RevisionAnalysis, signals []*automationSignal) {
+	for _, dim := range dimensions {
+		var strongest *automationSignal
+		for _, signal := range signals {
+			if weight, ok := signal.weights[dim]; ok && (strongest == nil || weight > strongest.weights[dim]) {
+				strongest = signal
+			}
+		}
+		if strongest != nil {
+			applyWeights(rc, strongest.factor, map[string]int{dim: strongest.weights[dim]})
+		}
+	}
+}
+
+// Empty if the editor's last edits, times oldest first, are neither a burst nor regular
+func cadence(times []time.Time) string {
+	if len(times) >= burstMinEdits {
+		last := times[len(times)-burstMinEdits:]
+		var maxGap time.Duration
+		for i := 1; i < len(last); i++ {
+			maxGap = max(maxGap, last[i].Sub(last[i-1]))
+		}
+		if maxGap <= burstMaxGap {
+			return fmt.Sprintf("cadence: %d edits at most %s apart", burstMinEdits, maxGap)
+		}
+	}
+
+	if len(times) < regularMinGaps+1 {
+		return ""
+	}
+	gaps := make([]time.Duration, 0, regularMinGaps)
+	var sum time.Duration
+	for i := 1; i < len(times); i++ {
+		gaps = append(gaps, times[i].Sub(times[i-1]))
+		sum += gaps[i-1]
+	}
+	mean := sum / time.Duration(len(gaps))
+	// Bursts are caught above
+	if mean <= burstMaxGap {
+		return ""
+	}
+	jitter := min(time.Duration(regularMaxSpread*float64(mean)), regularMaxJitter)
+	for _, gap := range gaps {
+		if (gap - mean).Abs() > jitter {
+			return ""
+		}
+	}
+	return fmt.Sprintf("cadence: %d edits every %s", len(times), mean.Round(time.Second))
+}
+
+// The summary without the section and with the numbers masked, what a tool's summaries share
+func commentSkeleton(comment string) string {
+	skeleton := reSectionComment.ReplaceAllString(comment, "")
+	skeleton = reDigits.ReplaceAllString(skeleton, "#")
+	return strings.Join(strings.Fields(strings.ToLower(skeleton)), " ")
+}
+
+// Tools fill in counts and link their docs, people mostly don't
+func toolLikeSkeleton(skeleton string) bool {
+	return strings.Contains(skeleton, "#") || strings.Contains(skeleton, "[[") || strings.Contains(skeleton, "http")
+}
+
+// The order of magnitude of a size change, +64..127 for 100
+func sizeBucket(delta int64) string {
+	if delta == 0 {
+		return "0"
+	}
+	sign := "+"
+	if delta < 0 {
+		sign, delta = "-", -delta
+	}
+	n := bits.Len64(uint64(delta))
+	return fmt.Sprintf("%s%d..%d", sign, uint64(1)<<(n-1), uint64(1)<<n-1)
+}
+
+func allSame(strs []string) bool {
+	for _, str := range strs[1:] {
+		if str != strs[0] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,307 @@
+package preprocessor
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Edit times from the gaps between them, starting at epoch
+func editTimes(gaps ...time.Duration) []time.Time {
+	times := []time.Time{epoch}
+	for _, gap := range gaps {
+		times = append(times, times[len(times)-1].Add(gap))
+	}
+	return times
+}
+
+// n gaps of mean, every other one off by jitter either way
+func jittered(n int, mean, jitter time.Duration) []time.Duration {
+	gaps := make([]time.Duration, n)
+	for i := range gaps {
+		gaps[i] = mean + jitter
+		if i%2 == 1 {
+			gaps[i] = mean - jitter
+		}
+	}
+	return gaps
+}
+
+func TestCadence(t *testing.T) {
+	cases := []struct {
+		name  string
+		times []time.Time
+		want  string
+	}{
+		{"too few for a burst", editTimes(time.Second, time.Second), ""},
+		{"burst", editTimes(10*time.Second, 5*time.Second, 10*time.Second), "cadence: 4 edits at most 10s apart"},
+		{"burst at the max gap", editTimes(burstMaxGap, burstMaxGap, burstMaxGap), "cadence: 4 edits at most 15s apart"},
+		{"one gap over the max", editTimes(10*time.Second, burstMaxGap+time.Second, 10*time.Second), ""},
+		// Only the last burstMinEdits count
+		{"burst after a pause", editTimes(time.Hour, time.Second, time.Second, time.Second), "cadence: 4 edits at most 1s apart"},
+
+		{"regular", editTimes(jittered(regularMinGaps, 10*time.Minute, 0)...), "cadence: 9 edits every 10m0s"},
+		{"one gap short of regular", editTimes(jittered(regularMinGaps-1, 10*time.Minute, 0)...), ""},
+		// 2% of 10m is 12s
+		{"within the spread", editTimes(jittered(regularMinGaps, 10*time.Minute, 12*time.Second)...), "cadence: 9 edits every 10m0s"},
+		{"over the spread", editTimes(jittered(regularMinGaps, 10*time.Minute, 13*time.Second)...), ""},
+		{"daily job", editTimes(jittered(regularMinGaps, 24*time.Hour, 30*time.Second)...), "cadence: 9 edits every 24h0m0s"},
+		// Within 2% of a day, over the jitter
+		{"daily human", editTimes(jittered(regularMinGaps, 24*time.Hour, 20*time.Minute)...), ""},
+		{"irregular", editTimes(time.Hour, 3*time.Hour, time.Hour, 2*time.Hour, time.Hour, time.Hour, time.Hour, time.Hour), ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := cadence(c.times); got != c.want {
+				t.Errorf("cadence %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestCommentSkeleton(t *testing.T) {
+	cases := []struct {
+		comment string
+		want    string
+	}{
+		{"", ""},
+		{"/* History */", ""},
+		{"/* History */ Fixed 3 typos", "fixed # typos"},
+		{"Fixed  12 TYPOS ", "fixed # typos"},
+		{"Rescuing 2 sources and tagging 0 as dead.) #IABot (v2.0.9.5", "rescuing # sources and tagging # as dead.) #iabot (v#.#.#.#"},
+	}
+	for _, c := range cases {
+		if got := commentSkeleton(c.comment); got != c.want {
+			t.Errorf("commentSkeleton(%q) = %q, want %q", c.comment, got, c.want)
+		}
+	}
+}
+
+func TestSizeBucket(t *testing.T) {
+	cases := []struct {
+		delta int64
+		want  string
+	}{
+		{0, "0"},
+		{1, "+1..1"},
+		{2, "+2..3"},
+		{100, "+64..127"},
+		{127, "+64..127"},
+		{128, "+128..255"},
+		{-1, "-1..1"},
+		{-100, "-64..127"},
+	}
+	for _, c := range cases {
+		if got := sizeBucket(c.delta); got != c.want {
+			t.Errorf("sizeBucket(%d) = %q, want %q", c.delta, got, c.want)
+		}
+	}
+}
+
+type detectorEdit struct {
+	editor  string
+	gap     time.Duration
+	delta   int64
+	comment string
+	tags    []string
+}
+
+// Runs the edits through a detector with the default rules, oldest first, page created by the first
+func detectEdits(t *testing.T, edits []detectorEdit) []*RevisionAnalysis {
+	t.Helper()
+
+	detector, err := newAutomationDetector(DefaultRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := []*RevisionAnalysis{}
+	at, size := epoch, int64(0)
+	for i, edit := range edits {
+		at, size = at.Add(edit.gap), size+edit.delta
+		rc := &RevisionAnalysis{
+			Process: &ProcessCtx{Meta: &RevisionMeta{
+				RevID:     i + 1,
+				ParentID:  i,
+				TimeStamp: at,
+				Size:      size,
+				Comment:   edit.comment,
+				Tags:      edit.tags,
+			}},
+			Tags:       &RevisionTags{},
+			Confidence: &RevisionConfidence{},
+			Trust:      &RevisionTrust{},
+		}
+		detector.detect(rc, edit.editor)
+		out = append(out, rc)
+	}
+	return out
+}
+
+func TestAutomationDetector(t *testing.T) {
+	awb := "Typo fixing, [[WP:AWB|AWB]]"
+	human := func(gap time.Duration, comment string) detectorEdit {
+		return detectorEdit{editor: "Human", gap: gap, delta: 50, comment: comment}
+	}
+
+	cases := []struct {
+		name  string
+		edits []detectorEdit
+		// Of the last edit
+		evidence    []string
+		automation  int
+		maintenance int
+	}{
+		{
+			name:        "tool comment",
+			edits:       []detectorEdit{{editor: "A", comment: awb, delta: 10}},
+			evidence:    []string{"comment: AWB"},
+			automation:  70,
+			maintenance: 40,
+		},
+		{
+			name:        "tool tag",
+			edits:       []detectorEdit{{editor: "A", comment: "typos", tags: []string{"AWB"}, delta: 10}},
+			evidence:    []string{"tag: AWB"},
+			automation:  70,
+			maintenance: 40,
+		},
+		{
+			name: "repetitive",
+			edits: []detectorEdit{
+				{editor: "A", gap: time.Hour, delta: 100, comment: "Fixed 1 links"},
+				{editor: "A", gap: time.Hour, delta: 120, comment: "Fixed 2 links"},
+				{editor: "A", gap: 3 * time.Hour, delta: 90, comment: "/* Lead */ Fixed 3 links"},
+			},
+			evidence:    []string{`repetitive: 3 edits of "fixed # links", +64..127 bytes`},
+			automation:  35,
+			maintenance: 20,
+		},
+		{
+			name: "another editor breaks nothing",
+			edits: []detectorEdit{
+				{editor: "A", gap: time.Hour, delta: 100, comment: "Fixed 1 links"},
+				{editor: "B", gap: time.Hour, delta: -5000, comment: "rm"},
+				{editor: "A", gap: time.Hour, delta: 120, comment: "Fixed 2 links"},
+				{editor: "A", gap: 3 * time.Hour, delta: 90, comment: "Fixed 3 links"},
+			},
+			evidence:    []string{`repetitive: 3 edits of "fixed # links", +64..127 bytes`},
+			automation:  35,
+			maintenance: 20,
+		},
+		{
+			name: "no summary breaks the row",
+			edits: []detectorEdit{
+				{editor: "A", gap: time.Hour, delta: 100, comment: "Fixed 1 links"},
+				{editor: "A", gap: time.Hour, delta: 100, comment: ""},
+				{editor: "A", gap: time.Hour, delta: 100, comment: "Fixed 2 links"},
+			},
+		},
+		{
+			// Nothing in the summary a tool would put there, three is a person fixing typos
+			name: "human repeating a summary",
+			edits: []detectorEdit{
+				{editor: "A", gap: time.Hour, delta: 1, comment: "fix typo"},
+				{editor: "A", gap: time.Hour, delta: 1, comment: "fix typo"},
+				{editor: "A", gap: 2 * time.Hour, delta: 1, comment: "fix typo"},
+			},
+		},
+		{
+			name: "long run of a plain summary",
+			edits: []detectorEdit{
+				{editor: "A", gap: time.Hour, delta: 1, comment: "fix typo"},
+				{editor: "A", gap: time.Hour, delta: 1, comment: "fix typo"},
+				{editor: "A", gap: 2 * time.Hour, delta: 1, comment: "fix typo"},
+				{editor: "A", gap: time.Hour, delta: 1, comment: "fix typo"},
+				{editor: "A", gap: 3 * time.Hour, delta: 1, comment: "fix typo"},
+				{editor: "A", gap: time.Hour, delta: 1, comment: "fix typo"},
+			},
+			evidence:    []string{`repetitive: 6 edits of "fix typo", +1..1 bytes`},
+			automation:  35,
+			maintenance: 20,
+		},
+		{
+			// Tool, bot summary, burst and repetitive all at once count as the strongest
+			name: "signals don't stack",
+			edits: []detectorEdit{
+				{editor: "A", gap: time.Hour, delta: 10, comment: "Bot: " + awb},
+				{editor: "A", gap: 5 * time.Second, delta: 10, comment: "Bot: " + awb},
+				{editor: "A", gap: 5 * time.Second, delta: 10, comment: "Bot: " + awb},
+				{editor: "A", gap: 5 * time.Second, delta: 10, comment: "Bot: " + awb},
+			},
+			evidence: []string{
+				"comment: AWB",
+				"comment: bot summary",
+				"cadence: 4 edits at most 5s apart",
+				`repetitive: 3 edits of "bot: typo fixing, [[wp:awb|awb]]", +8..15 bytes`,
+			},
+			automation:  70,
+			maintenance: 40,
+		},
+		{
+			name: "human on a schedule",
+			edits: []detectorEdit{
+				human(0, "expand"), human(24*time.Hour+20*time.Minute, "refs"), human(24*time.Hour-20*time.Minute, "copyedit"),
+				human(24*time.Hour+20*time.Minute, "expand"), human(24*time.Hour-20*time.Minute, "history"),
+				human(24*time.Hour+20*time.Minute, "lead"), human(24*time.Hour-20*time.Minute, "expand"),
+				human(24*time.Hour+20*time.Minute, "refs"), human(24*time.Hour-20*time.Minute, "see also"),
+			},
+		},
+		{
+			// The editor is unknown, only the tools count
+			name: "no editor",
+			edits: []detectorEdit{
+				{gap: time.Hour, delta: 10, comment: "x"},
+				{gap: time.Second, delta: 10, comment: "x"},
+				{gap: time.Second, delta: 10, comment: "x"},
+				{gap: time.Second, delta: 10, comment: "x"},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			revs := detectEdits(t, c.edits)
+			last := revs[len(revs)-1]
+
+			if last.Tags.IsAutomated != (len(c.evidence) > 0) {
+				t.Errorf("automated %t", last.Tags.IsAutomated)
+			}
+			if !slices.Equal(last.Tags.AutomationEvidence, c.evidence) {
+				t.Errorf("evidence %q, want %q", last.Tags.AutomationEvidence, c.evidence)
+			}
+			if last.Confidence.Automation != c.automation || last.Confidence.Maintenance != c.maintenance {
+				t.Errorf("automation %d, maintenance %d, want %d, %d",
+					last.Confidence.Automation, last.Confidence.Maintenance, c.automation, c.maintenance)
+			}
+		})
+	}
+}
+
+// The strongest signal of each dimension is the only factor
+func TestAutomationFactors(t *testing.T) {
+	revs := detectEdits(t, []detectorEdit{
+		{editor: "A", gap: time.Hour, delta: 10, comment: "Fixed 1 links"},
+		{editor: "A", gap: time.Second, delta: 10, comment: "Fixed 1 links"},
+		{editor: "A", gap: time.Second, delta: 10, comment: "Fixed 1 links"},
+		{editor: "A", gap: time.Second, delta: 10, comment: "Fixed 1 links"},
+	})
+	last := revs[len(revs)-1]
+
+	got := []string{}
+	for _, factor := range last.Trust.Factors {
+		got = append(got, factor.Factor+"/"+factor.Dimension)
+	}
+	// Cadence 40 over repetitive 35 for automation, repetitive alone has maintenance
+	want := []string{"cadence/" + DimAutomation, "repetitive/" + DimMaintenance}
+	if !slices.Equal(got, want) {
+		t.Errorf("factors %v, want %v", got, want)
+	}
+
+	// A group on top reinforces it, not past 100
+	before := last.Confidence.Automation
+	DefaultRules().apply(last, TagGlobalBot, false)
+	if want := reinforce(before, 80); last.Confidence.Automation != want || want >= 100 {
+		t.Errorf("automation %d with global-bot, want %d", last.Confidence.Automation, want)
+	}
+}
@@ -0,0 +1,197 @@
+package preprocessor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"evolve/debugger"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Thresholds of the classifiers
+const (
+	// A micro edit changes at most this many bytes of wikitext...
+	microEditMaxBytes = 20
+	// ...and at most this many words of the cleaned text, inserted plus deleted
+	microEditMaxWords = 3
+
+	// A content expansion inserts at least this many words...
+	expansionMinWords = 50
+	// ...and this many times more than it deletes
+	expansionMinRatio = 3
+)
+
+var (
+	// Templates that cite or ask for a citation, by name
+	reCiteTemplate = regexp.MustCompile(`(?is)\{\{\s*(cite[ _]\w+|citation|citation needed|cn|sfn\w*|harv\w*|refn|efn|reflist|rp)\s*(\|[^{}]*)?\}\}`)
+	reRefList      = regexp.MustCompile(`(?is)<references\s*/>|<references\b[^>]*>.*?</references\s*>`)
+)
+
+// The tags that compare the wikitext with the parent's, and the ones the diff counts are enough for
+var (
+	wikitextTags = []string{"isMicroEdit", "isCitationOnly", "isStructural"}
+	diffTags     = []string{"isContentExpansion", "isDefinitionChange"}
+)
+
+// Fills the RevisionTags that come from what the edit changed, once the revision is diffed
+type Classifier struct {
+	// Where the wikitext of parents cleaned by an earlier run is read back from
+	rawDir string
+
+	ctx      context.Context
+	dumpDir  string
+	metrics  *Metrics
+	debugger *debugger.Debugger
+}
+
+func NewClassifier(commons *Commons, rawDir string) *Classifier {
+	return &Classifier{
+		rawDir:   rawDir,
+		ctx:      commons.ctx,
+		dumpDir:  commons.dumpDir,
+		metrics:  commons.metrics,
+		debugger: commons.debugger,
+	}
+}
+
+// parent is the one the differ diffed against, nil if it couldn't be found
+func (s *Classifier) classifyRev(rc *RevisionAnalysis, r *RevisionClean, parent *RevisionClean) error {
+	tags := rc.Tags
+	// The differ already warned about it, the diff counts are against an empty text
+	if parent == nil {
+		tags.Unknown = append(slices.Clone(wikitextTags), diffTags...)
+		return nil
+	}
+
+	diffs := rc.Diffs
+
+	// Reverts restore text, they don't expand the article
+	tags.IsContentExpansion = !tags.IsRevert &&
+		diffs.Inserted >= expansionMinWords &&
+		diffs.Inserted >= expansionMinRatio*diffs.Deleted
+
+	// The first sentence of the lead usually is the definition, the creation doesn't change it
+	tags.IsDefinitionChange = rc.Process.Meta.ParentID != 0 &&
+		leadSentence(parent) != leadSentence(r)
+
+	parentFeatures, found, err := s.parentFeatures(parent)
+	if err != nil {
+		rc.Debug.Warnings = append(rc.Debug.Warnings, err)
+	}
+	if !found || r.Features == nil {
+		tags.Unknown = slices.Clone(wikitextTags)
+		return nil
+	}
+
+	changed := parentFeatures.sum != r.Features.sum
+	bytesDelta := r.Features.size - parentFeatures.size
+
+	// Typo fixes and the like, small in the wikitext and in the text
+	tags.IsMicroEdit = changed &&
+		abs(bytesDelta) <= microEditMaxBytes &&
+		diffs.Inserted+diffs.Deleted <= microEditMaxWords
+
+	// Only refs, cite templates and citation needed tags changed
+	tags.IsCitationOnly = changed && parentFeatures.citationless == r.Features.citationless
+
+	// Headings, templates, categories, tables or section order changed, not the prose
+	tags.IsStructural = changed && !tags.IsCitationOnly && sameProse(parent, r)
+
+	return nil
+}
+
+// What the classifier compares of a revision's wikitext with its parent's. Kept with the
+// cleaned text in the differ's cache instead of the wikitext, a few hashes per revision.
+type wikitextFeatures struct {
+	size int
+	sum  [sha256.Size]byte
+	// Of the wikitext without citations, see stripCitations
+	citationless [sha256.Size]byte
+}
+
+func newWikitextFeatures(wikitext string) *wikitextFeatures {
+	return &wikitextFeatures{
+		size:         len(wikitext),
+		sum:          sha256.Sum256([]byte(wikitext)),
+		citationless: sha256.Sum256([]byte(stripCitations(wikitext))),
+	}
+}
+
+// The features of the parent, from the cache or its wikitext in revs/, not found if neither has it
+func (s *Classifier) parentFeatures(parent *RevisionClean) (*wikitextFeatures, bool, error) {
+	if parent.Features != nil {
+		return parent.Features, true, nil
+	}
+	if parent.RevID == 0 {
+		return newWikitextFeatures(""), true, nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(s.rawDir, fmt.Sprintf("*-%d.json", parent.RevID)))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(matches) == 0 {
+		return nil, false, fmt.Errorf("wikitext of parent %d not found", parent.RevID)
+	}
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		return nil, false, err
+	}
+	revRaw := new(RevisionContent)
+	if err = json.Unmarshal(data, revRaw); err != nil {
+		return nil, false, fmt.Errorf("parent %d unmarshall error: %v", parent.RevID, err)
+	}
+	return newWikitextFeatures(revRaw.Slots.Main.Content), true, nil
+}
+
+// The wikitext without refs and citation templates, whitespace collapsed
+func stripCitations(wikitext string) string {
+	txt := reComment.ReplaceAllString(wikitext, "")
+	txt = reRef.ReplaceAllString(txt, "")
+	txt = reRefList.ReplaceAllString(txt, "")
+	txt = replaceUntilStable(txt, reCiteTemplate, func(string) string { return "" })
+	return strings.Join(strings.Fields(txt), " ")
+}
+
+// Whether both have the same section texts, in any order and under any heading
+func sameProse(parent, r *RevisionClean) bool {
+	if parent.Sections == nil || r.Sections == nil {
+		return strings.Join(strings.Fields(parent.Content), " ") == strings.Join(strings.Fields(r.Content), " ")
+	}
+
+	texts := func(root *Section) []string {
+		out := []string{}
+		for _, sec := range root.Flatten() {
+			if txt := strings.Join(strings.Fields(sec.Text), " "); txt != "" {
+				out = append(out, txt)
+			}
+		}
+		slices.Sort(out)
+		return out
+	}
+	return slices.Equal(texts(parent.Sections), texts(r.Sections))
+}
+
+func leadSentence(r *RevisionClean) string {
+	lead := r.Content
+	if r.Sections != nil {
+		lead = r.Sections.Text
+	}
+	sentences := SplitSentences(lead)
+	if len(sentences) == 0 {
+		return ""
+	}
+	return sentences[0]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
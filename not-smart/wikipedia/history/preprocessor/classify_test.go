@@ -0,0 +1,297 @@
+package preprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func cleanRevision(t *testing.T, revID, parentID int, wikitext string) *RevisionClean {
+	t.Helper()
+
+	content, sections, err := cleanSections(context.Background(), NewNativeBackend(), wikitext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &RevisionClean{RevID: revID, ParentID: parentID, Content: content, Sections: sections, Features: newWikitextFeatures(wikitext)}
+}
+
+// Classifies child as an edit of parent, "" parent is a page creation
+func classifyPair(t *testing.T, parentWikitext, childWikitext string) *RevisionTags {
+	t.Helper()
+
+	parentID := 1
+	parent := &RevisionClean{}
+	if parentWikitext != "" {
+		parent = cleanRevision(t, parentID, 0, parentWikitext)
+	} else {
+		parentID = 0
+	}
+	child := cleanRevision(t, 2, parentID, childWikitext)
+
+	rc := &RevisionAnalysis{
+		Process: &ProcessCtx{Meta: &RevisionMeta{RevID: 2, ParentID: parentID}},
+		Tags:    new(RevisionTags),
+		Diffs:   new(RevisionDiffs),
+		Debug:   new(RevisionDebug),
+	}
+	inserted, deleted, _ := wordCounts(strings.Fields(parent.Content), strings.Fields(child.Content))
+	rc.Diffs.Inserted, rc.Diffs.Deleted = int(inserted), int(deleted)
+
+	classifier := &Classifier{rawDir: t.TempDir()}
+	if err := classifier.classifyRev(rc, child, parent); err != nil {
+		t.Fatal(err)
+	}
+	return rc.Tags
+}
+
+// n distinct words
+func words(n int) string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = "w" + strings.Repeat("x", i%7) + string(rune('a'+i%26))
+	}
+	return strings.Join(out, " ")
+}
+
+const (
+	mlLead    = "Machine learning is a field of study in artificial intelligence. It learns from data."
+	mlHistory = "== History ==\nThe term was coined in 1959 by Arthur Samuel.\n"
+	mlUses    = "== Uses ==\nIt is used in email filtering.\n"
+)
+
+func TestClassifyPairs(t *testing.T) {
+	tests := []struct {
+		name   string
+		parent string
+		child  string
+		// The tags set, of micro, citation, structural, expansion and definition
+		want []string
+	}{
+		{
+			name:   "typo fix",
+			parent: mlLead + "\n" + mlHistory,
+			child:  mlLead + "\n" + strings.Replace(mlHistory, "coined", "coinde", 1),
+			want:   []string{"micro"},
+		},
+		{
+			name:   "21 bytes",
+			parent: mlLead + "\n" + mlHistory,
+			child:  mlLead + "\n" + strings.Replace(mlHistory, "in 1959", "in 1959 at Poughkeepsie IBMs", 1),
+		},
+		{
+			name:   "4 words",
+			parent: mlLead + "\n" + mlHistory,
+			child:  mlLead + "\n" + strings.Replace(mlHistory, "in 1959", "in 1959 at an IBM lab", 1),
+		},
+		{
+			name:   "citation added",
+			parent: mlLead + "\n" + mlHistory,
+			child:  mlLead + "\n" + strings.Replace(mlHistory, "Samuel.", "Samuel.<ref>{{cite journal |last=Samuel |title=Some Studies in Machine Learning |year=1959}}</ref>", 1),
+			want:   []string{"citation"},
+		},
+		{
+			// Small enough to be a micro edit too
+			name:   "citation needed",
+			parent: mlLead + "\n" + mlHistory,
+			child:  mlLead + "\n" + strings.Replace(mlHistory, "Samuel.", "Samuel.{{cn}}", 1),
+			want:   []string{"micro", "citation"},
+		},
+		{
+			name:   "reference list",
+			parent: mlLead + "\n" + mlHistory,
+			child:  mlLead + "\n" + mlHistory + "{{Reflist|colwidth=30em}}\n",
+			want:   []string{"citation"},
+		},
+		{
+			name:   "sections swapped",
+			parent: mlLead + "\n" + mlHistory + mlUses,
+			child:  mlLead + "\n" + mlUses + mlHistory,
+			want:   []string{"structural"},
+		},
+		{
+			name:   "category added",
+			parent: mlLead + "\n" + mlHistory,
+			child:  mlLead + "\n" + mlHistory + "[[Category:Machine learning]]\n",
+			want:   []string{"structural"},
+		},
+		{
+			name:   "definition changed",
+			parent: mlLead + "\n" + mlHistory,
+			child:  strings.Replace(mlLead, "a field of study in artificial intelligence", "the study of algorithms that improve through experience", 1) + "\n" + mlHistory,
+			want:   []string{"definition"},
+		},
+		{
+			name:   "second sentence of the lead",
+			parent: mlLead + "\n" + mlHistory,
+			child:  strings.Replace(mlLead, "It learns from data.", "It learns from examples and improves with more of them.", 1) + "\n" + mlHistory,
+		},
+		{
+			name:   "expansion",
+			parent: mlLead + "\n" + mlHistory,
+			child:  mlLead + "\n" + mlHistory + mlUses + words(expansionMinWords) + "\n",
+			want:   []string{"expansion"},
+		},
+		{
+			name:  "creation",
+			child: mlLead + "\n" + mlHistory + words(expansionMinWords),
+			want:  []string{"expansion"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tags := classifyPair(t, test.parent, test.child)
+			got := []string{}
+			for name, set := range map[string]bool{
+				"micro":      tags.IsMicroEdit,
+				"citation":   tags.IsCitationOnly,
+				"structural": tags.IsStructural,
+				"expansion":  tags.IsContentExpansion,
+				"definition": tags.IsDefinitionChange,
+			} {
+				if set {
+					got = append(got, name)
+				}
+			}
+			slices.Sort(got)
+			want := slices.Sorted(slices.Values(test.want))
+			if !slices.Equal(got, want) && !(len(got) == 0 && len(want) == 0) {
+				t.Errorf("tags %v, want %v", got, want)
+			}
+			if tags.Unknown != nil {
+				t.Errorf("unknown %v", tags.Unknown)
+			}
+		})
+	}
+}
+
+func TestMicroEditThresholds(t *testing.T) {
+	tests := []struct {
+		bytes int
+		words int
+		want  bool
+	}{
+		{bytes: microEditMaxBytes, words: microEditMaxWords, want: true},
+		{bytes: -microEditMaxBytes, words: microEditMaxWords, want: true},
+		{bytes: microEditMaxBytes + 1, words: 1},
+		{bytes: -microEditMaxBytes - 1, words: 1},
+		{bytes: 1, words: microEditMaxWords + 1},
+		// Same wikitext, a null edit
+		{bytes: 0, words: 0},
+	}
+
+	base := mlLead + "\n" + mlHistory
+	classifier := &Classifier{rawDir: t.TempDir()}
+	for _, test := range tests {
+		parentWikitext, childWikitext := base, base+strings.Repeat("x", max(test.bytes, 0))
+		if test.bytes < 0 {
+			parentWikitext, childWikitext = base+strings.Repeat("x", -test.bytes), base
+		}
+		rc := &RevisionAnalysis{
+			Process: &ProcessCtx{Meta: &RevisionMeta{RevID: 2, ParentID: 1}},
+			Tags:    new(RevisionTags),
+			Diffs:   &RevisionDiffs{Inserted: test.words},
+			Debug:   new(RevisionDebug),
+		}
+		err := classifier.classifyRev(rc, cleanRevision(t, 2, 1, childWikitext), cleanRevision(t, 1, 0, parentWikitext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rc.Tags.IsMicroEdit != test.want {
+			t.Errorf("%d bytes, %d words: micro %t", test.bytes, test.words, rc.Tags.IsMicroEdit)
+		}
+	}
+}
+
+func TestContentExpansionThresholds(t *testing.T) {
+	tests := []struct {
+		inserted, deleted int
+		revert            bool
+		want              bool
+	}{
+		{inserted: expansionMinWords, want: true},
+		{inserted: expansionMinWords - 1},
+		{inserted: 60, deleted: 20, want: true},
+		{inserted: 60, deleted: 21},
+		{inserted: 500, revert: true},
+	}
+
+	parent := cleanRevision(t, 1, 0, mlLead)
+	child := cleanRevision(t, 2, 1, mlLead+" "+words(10))
+	classifier := &Classifier{rawDir: t.TempDir()}
+	for _, test := range tests {
+		rc := &RevisionAnalysis{
+			Process: &ProcessCtx{Meta: &RevisionMeta{RevID: 2, ParentID: 1}},
+			Tags:    &RevisionTags{IsRevert: test.revert},
+			Diffs:   &RevisionDiffs{Inserted: test.inserted, Deleted: test.deleted},
+			Debug:   new(RevisionDebug),
+		}
+		if err := classifier.classifyRev(rc, child, parent); err != nil {
+			t.Fatal(err)
+		}
+		if rc.Tags.IsContentExpansion != test.want {
+			t.Errorf("%d inserted, %d deleted, revert %t: expansion %t", test.inserted, test.deleted, test.revert, rc.Tags.IsContentExpansion)
+		}
+	}
+}
+
+func TestClassifyWithoutParent(t *testing.T) {
+	child := cleanRevision(t, 2, 1, mlLead)
+	newRC := func() *RevisionAnalysis {
+		return &RevisionAnalysis{
+			Process: &ProcessCtx{Meta: &RevisionMeta{RevID: 2, ParentID: 1}},
+			Tags:    new(RevisionTags),
+			Diffs:   &RevisionDiffs{Inserted: 3},
+			Debug:   new(RevisionDebug),
+		}
+	}
+	classifier := &Classifier{rawDir: t.TempDir()}
+
+	// The differ couldn't find it, its diff is against an empty text
+	rc := newRC()
+	if err := classifier.classifyRev(rc, child, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := append(slices.Clone(wikitextTags), diffTags...); !slices.Equal(rc.Tags.Unknown, want) {
+		t.Errorf("unknown %v, want %v", rc.Tags.Unknown, want)
+	}
+	if rc.Tags.IsMicroEdit || rc.Tags.IsDefinitionChange {
+		t.Errorf("tagged against a missing parent: %+v", rc.Tags)
+	}
+
+	// Cleaned by an earlier run, its wikitext is gone from revs/
+	rc = newRC()
+	parent := cleanRevision(t, 1, 0, mlLead+" More.")
+	parent.Features = nil
+	if err := classifier.classifyRev(rc, child, parent); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(rc.Tags.Unknown, wikitextTags) {
+		t.Errorf("unknown %v, want %v", rc.Tags.Unknown, wikitextTags)
+	}
+	if rc.Tags.IsMicroEdit || len(rc.Debug.Warnings) != 1 {
+		t.Errorf("micro %t, warnings %v", rc.Tags.IsMicroEdit, rc.Debug.Warnings)
+	}
+
+	// Out of the cache, its wikitext is read back from revs/
+	rc = newRC()
+	revRaw := &RevisionContent{RevID: 1, Slots: RevisionContentSlots{Main: RevisionContentSlotsMain{Content: mlLead + " More."}}}
+	data, err := json.Marshal(revRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(classifier.rawDir, "1700000000-1.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = classifier.classifyRev(rc, child, parent); err != nil {
+		t.Fatal(err)
+	}
+	if len(rc.Tags.Unknown) != 0 || !rc.Tags.IsMicroEdit {
+		t.Errorf("unknown %v, micro %t", rc.Tags.Unknown, rc.Tags.IsMicroEdit)
+	}
+}
@@ -1,27 +1,44 @@
 package preprocessor
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"evolve/debugger"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"time"
 )
 
+// Turns the wikitext of a revision into plain text
+type CleanBackend interface {
+	Clean(ctx context.Context, wikitext string) (string, error)
+	// Releases whatever the backend started
+	Close() error
+}
+
+// Backends selectable by name
+const (
+	CleanerNative = "native"
+	CleanerPandoc = "pandoc"
+)
+
+// The backend called name, ctx bounds whatever it starts
+func NewCleanBackend(ctx context.Context, name string, pandocServers int, debugger *debugger.Debugger) (CleanBackend, error) {
+	switch name {
+	case CleanerNative, "":
+		return NewNativeBackend(), nil
+	case CleanerPandoc:
+		return NewPandocBackend(ctx, pandocServers, nil, debugger)
+	default:
+		return nil, fmt.Errorf("unknown cleaner %q, expected %s or %s", name, CleanerNative, CleanerPandoc)
+	}
+}
+
 type Cleaner struct {
 	cleanDir string
 	rawDir   string
 
-	pandocServerCount int
-	pandocRRURLChan   chan string
-	pandocHTTPClient  *http.Client
-	pandocCtxCancel   context.CancelFunc
+	backend CleanBackend
 
 	ctx      context.Context
 	dumpDir  string
@@ -29,10 +46,11 @@ type Cleaner struct {
 	debugger *debugger.Debugger
 }
 
-func NewCleaner(commons *Commons, rawDir, cleanDir string) (*Cleaner, error) {
+func NewCleaner(commons *Commons, rawDir, cleanDir string, backend CleanBackend) (*Cleaner, error) {
 	c := &Cleaner{
 		cleanDir: cleanDir,
 		rawDir:   rawDir,
+		backend:  backend,
 
 		ctx:      commons.ctx,
 		dumpDir:  commons.dumpDir,
@@ -45,149 +63,78 @@ func NewCleaner(commons *Commons, rawDir, cleanDir string) (*Cleaner, error) {
 		return nil, err
 	}
 
-	c.pandocServerCount = 3
-	c.pandocRRURLChan = make(chan string, c.pandocServerCount)
-	c.pandocHTTPClient = &http.Client{
-		Timeout: 10 * time.Second,
-	}
-	c.startPandocServers()
-
 	return c, nil
 }
 
-func (s *Cleaner) startPandocServers() error {
-	pandocCtx, cancel := context.WithCancel(s.ctx)
-	s.pandocCtxCancel = cancel
-	
-	for i := range s.pandocServerCount {
-		port := fmt.Sprintf("%d", 3030+i)
-
-		cmd := exec.CommandContext(pandocCtx, "pandoc-server",
-			"--port", port,
-			"--timeout", "30",
-		)
-
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-
-		if err := cmd.Start(); err != nil {
-			return fmt.Errorf("pandoc-server start ERROR: %v", err)
-		}
-
-		go func() {
-			cmd.Wait()
-		}()
-
-		url := fmt.Sprintf("http://localhost:%s", port)
-		s.pandocRRURLChan <- url
-
-		for {
-			_, err := http.Get(url)
-			if err == nil {
-				break
-			}
-			time.Sleep(100 * time.Millisecond)
-		}
-	}
-
-	return nil
-}
-
-type PandocReq struct {
-	Text       string   `json:"text"`
-	From       string   `json:"from"`
-	To         string   `json:"to"`
-	Extensions []string `json:"extensions"`
-	Wrap       string   `json:"wrap"`
-}
-
-type PandocResp struct {
-	Output string `json:"output"`
-	Base64 bool   `json:"base64"`
-	// Message []struct {
-	// 	Verbosity string `json:"verbosity"`
-	// 	Message   string `json:"message"`
-	// } `json:"messages"`
+func (s *Cleaner) Close() error {
+	return s.backend.Close()
 }
 
 func (s *Cleaner) cleanRev(rc *RevisionAnalysis) (*RevisionClean, error) {
 	revFName := fmt.Sprintf("%d-%d.json", rc.Process.Meta.TimeStamp.Unix(), rc.Process.Meta.RevID)
-	revPath := filepath.Join(s.rawDir, revFName)
 
-	revData, err := os.ReadFile(revPath)
+	revRaw, err := s.readRaw(rc.Process.Meta, revFName)
 	if err != nil {
 		return nil, err
 	}
-	revRaw := new(RevisionContent)
-	err = json.Unmarshal(revData, revRaw)
+
+	content, sections, err := cleanSections(s.ctx, s.backend, revRaw.Slots.Main.Content)
 	if err != nil {
 		return nil, err
 	}
 
-	// Pandoc
-
-	pandocReq := PandocReq{
-		Text:       revRaw.Slots.Main.Content,
-		From:       "mediawiki",
-		To:         "plain",
-		Extensions: []string{"strip-comments"},
-		Wrap:       "none",
-	}
-	pandocReqBytes, err := json.Marshal(pandocReq)
-	if err != nil {
-		return nil, fmt.Errorf("pandoc body marshall err: %v", err)
+	revClean := &RevisionClean{
+		RevID:         revRaw.RevID,
+		ParentID:      revRaw.ParentID,
+		TimeStamp:     revRaw.TimeStamp,
+		ContentFormat: "plaintext",
+		Content:       content,
+		Sections:      sections,
+		Features:      newWikitextFeatures(revRaw.Slots.Main.Content),
 	}
-
-	url := <-s.pandocRRURLChan
-	defer func() { s.pandocRRURLChan <- url }()
-
-	req, err := http.NewRequest("POST", url, bytes.NewReader(pandocReqBytes))
+	revCleanBytes, err := json.MarshalIndent(revClean, "", " ")
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Content-Type", "application/json")
 
-	resp, err := s.pandocHTTPClient.Do(req)
+	outPath := filepath.Join(s.cleanDir, revFName)
+	err = os.WriteFile(outPath, revCleanBytes, 0700)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("pandoc returned non-200 ERROR")
-	}
+	return revClean, nil
+}
 
-	pandocRespBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+// The wikitext of the revision without taking the streamed content, for the revert detector
+func (s *Cleaner) wikitext(meta *RevisionMeta) (string, bool) {
+	if meta.Content != nil {
+		return meta.Content.Slots.Main.Content, true
 	}
-
-	pandocResp := new(PandocResp)
-	err = json.Unmarshal(pandocRespBytes, pandocResp)
+	revRaw, err := s.readRaw(meta, fmt.Sprintf("%d-%d.json", meta.TimeStamp.Unix(), meta.RevID))
 	if err != nil {
-		return nil, fmt.Errorf("pandoc resp unmarshall err: %v", err)
+		s.debugger.Debug(fmt.Sprintf("revert detection without the content of %d: %v", meta.RevID, err))
+		return "", false
 	}
+	return revRaw.Slots.Main.Content, true
+}
 
-	//
-
-	revClean := &RevisionClean{
-		RevID:         revRaw.RevID,
-		ParentID:      revRaw.ParentID,
-		TimeStamp:     revRaw.TimeStamp,
-		ContentFormat: "plaintext",
-		Content:       pandocResp.Output,
+// The streamed content if there is one, the file in revs/ otherwise
+func (s *Cleaner) readRaw(meta *RevisionMeta, revFName string) (*RevisionContent, error) {
+	if meta.Content != nil {
+		revRaw := meta.Content
+		meta.Content = nil
+		return revRaw, nil
 	}
-	revCleanBytes, err := json.MarshalIndent(revClean, "", " ")
+
+	revData, err := os.ReadFile(filepath.Join(s.rawDir, revFName))
 	if err != nil {
 		return nil, err
 	}
-
-	outPath := filepath.Join(s.cleanDir, revFName)
-	err = os.WriteFile(outPath, revCleanBytes, 0700)
+	revRaw := new(RevisionContent)
+	err = json.Unmarshal(revData, revRaw)
 	if err != nil {
 		return nil, err
 	}
-
-	return revClean, nil
+	return revRaw, nil
 }
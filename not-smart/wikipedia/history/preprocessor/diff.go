@@ -1,10 +1,16 @@
 package preprocessor
 
 import (
+	"container/list"
 	"context"
+	"encoding/json"
 	"evolve/debugger"
+	"fmt"
 	"math"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/sergi/go-diff/diffmatchpatch"
 )
@@ -13,38 +19,68 @@ type Differ struct {
 	// In  chan *RevisionAnalysis
 	// Out chan error
 
+	// Where parents that fell out of texts are read back from
+	cleanDir     string
+	sentencesDir string
+	texts        *textCache
+
 	ctx      context.Context
 	dumpDir  string
 	metrics  *Metrics
 	debugger *debugger.Debugger
 }
 
-func NewDiffer(commons *Commons) *Differ {
+func NewDiffer(commons *Commons, cleanDir, sentencesDir string) (*Differ, error) {
 	// func NewDiffer(commons *Commons, in chan *RevisionAnalysis, out chan error) *Differ {
-	return &Differ{
+	d := &Differ{
 		// In:       in,
 		// Out:      out,
-		ctx:      commons.ctx,
-		dumpDir:  commons.dumpDir,
-		metrics:  commons.metrics,
-		debugger: commons.debugger,
+		cleanDir:     cleanDir,
+		sentencesDir: sentencesDir,
+		texts:        newTextCache(512),
+		ctx:          commons.ctx,
+		dumpDir:      commons.dumpDir,
+		metrics:      commons.metrics,
+		debugger:     commons.debugger,
 	}
-}
 
-// Empty if first Revision, will still compare
-var localLastRevTxtCache = ""
+	err := os.MkdirAll(d.sentencesDir, 0700)
+	if err != nil {
+		return nil, err
+	}
 
-func (s *Differ) analyzeDiff(rc *RevisionAnalysis, r *RevisionClean) error {
-	dmp := diffmatchpatch.New()
+	return d, nil
+}
 
-	diffs := dmp.DiffMain(localLastRevTxtCache, r.Content, false)
+// Returns the parent it diffed against, nil if it couldn't be found and the diff is against an empty text
+func (s *Differ) analyzeDiff(rc *RevisionAnalysis, r *RevisionClean) (*RevisionClean, error) {
+	// Empty if first Revision, will still compare
+	parent, err := s.parentClean(rc.Process.Meta.ParentID)
+	found := err == nil
+	if err != nil {
+		rc.Debug.Warnings = append(rc.Debug.Warnings, err)
+	}
+	parentTxt := parent.Content
 
-	// dmp.DiffCleanupSemantic(diffs)
-	// dmp.DiffCleanupEfficiency(diffs)
+	// >>>
+
+	parentSentences, err := s.parentSentences(parent)
+	if err != nil {
+		rc.Debug.Warnings = append(rc.Debug.Warnings, err)
+	}
+	sentences, sentenceDiffs := alignSentences(parentSentences, SplitSentences(r.Content), r.RevID)
+	err = s.saveSentences(rc, sentences)
+	if err != nil {
+		return nil, err
+	}
+	r.Sentences = sentences
+	rc.Diffs.Sentences = sentenceDiffs
+	// Children wait for the sentence IDs, not only the text
+	s.texts.put(r.RevID, r)
 
 	// >>>
 
-	oldWords := strings.Fields(localLastRevTxtCache)
+	oldWords := strings.Fields(parentTxt)
 	newWords := strings.Fields(r.Content)
 
 	// >>>
@@ -71,36 +107,16 @@ func (s *Differ) analyzeDiff(rc *RevisionAnalysis, r *RevisionClean) error {
 
 	// >>>
 
-	oldJoined := strings.Join(oldWords, "\n")
-	newJoined := strings.Join(newWords, "\n")
-
-	diffs = dmp.DiffMain(oldJoined, newJoined, false)
-
-	countWords := func(s string) int {
-		if s == "" {
-			return 0
-		}
-		return strings.Count(s, "\n") + 1
-	}
-
-	var inserted, deleted, unchanged float64
-	for _, d := range diffs {
-		switch d.Type {
-		case diffmatchpatch.DiffInsert:
-			inserted += float64(countWords(d.Text))
-		case diffmatchpatch.DiffDelete:
-			deleted += float64(countWords(d.Text))
-		case diffmatchpatch.DiffEqual:
-			unchanged += float64(countWords(d.Text))
-		}
-	}
+	inserted, deleted, unchanged := wordCounts(oldWords, newWords)
 
 	rc.Diffs.Inserted = int(inserted)
 	rc.Diffs.Deleted = int(deleted)
 	rc.Diffs.Unchanged = int(unchanged)
 
-	// set the last as cache
-	localLastRevTxtCache = r.Content
+	// A parent cleaned before sections were kept, or not found, says nothing about them
+	if r.Sections != nil && (parent.Sections != nil || rc.Process.Meta.ParentID == 0) {
+		rc.Diffs.Sections = diffSections(parent.Sections, r.Sections)
+	}
 
 	// >>>
 
@@ -138,7 +154,214 @@ func (s *Differ) analyzeDiff(rc *RevisionAnalysis, r *RevisionClean) error {
 	rc.Diffs.BalanceScore = int(balance * 100)
 	rc.Diffs.TypeOfEdit = editType
 
-	return nil
+	if !found {
+		return nil, nil
+	}
+	return parent, nil
+}
+
+// Word level insertions, deletions and unchanged words between two texts split in words
+func wordCounts(oldWords, newWords []string) (inserted, deleted, unchanged float64) {
+	oldJoined := strings.Join(oldWords, "\n")
+	newJoined := strings.Join(newWords, "\n")
+
+	dmp := diffmatchpatch.New()
+	diffs := dmp.DiffMain(oldJoined, newJoined, false)
+
+	countWords := func(s string) int {
+		if s == "" {
+			return 0
+		}
+		return strings.Count(s, "\n") + 1
+	}
+
+	for _, d := range diffs {
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			inserted += float64(countWords(d.Text))
+		case diffmatchpatch.DiffDelete:
+			deleted += float64(countWords(d.Text))
+		case diffmatchpatch.DiffEqual:
+			unchanged += float64(countWords(d.Text))
+		}
+	}
+	return inserted, deleted, unchanged
+}
+
+// The cleaned parent, from this run if it's in it, from clean/ otherwise.
+// A parent that can't be found, e.g. one before the scraped window, is diffed as empty with the error.
+// Never nil.
+func (s *Differ) parentClean(parentID int) (*RevisionClean, error) {
+	empty := &RevisionClean{RevID: parentID}
+	if parentID == 0 {
+		return empty, nil
+	}
+
+	parent, found, err := s.texts.get(s.ctx, parentID)
+	if err != nil {
+		return empty, err
+	}
+	if found {
+		return parent, nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(s.cleanDir, fmt.Sprintf("*-%d.json", parentID)))
+	if err != nil {
+		return empty, err
+	}
+	if len(matches) == 0 {
+		return empty, fmt.Errorf("parent %d not found, diffed against an empty text", parentID)
+	}
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		return empty, err
+	}
+	parent = new(RevisionClean)
+	if err = json.Unmarshal(data, parent); err != nil {
+		return empty, fmt.Errorf("parent %d unmarshall error: %v", parentID, err)
+	}
+
+	return parent, nil
+}
+
+// The parent's sentences with their IDs. A parent processed before sentences were kept
+// starts new IDs, its lineage is lost.
+func (s *Differ) parentSentences(parent *RevisionClean) ([]*Sentence, error) {
+	if parent.Sentences != nil || parent.Content == "" {
+		return parent.Sentences, nil
+	}
+
+	sentences, err := s.readSentences(parent.RevID)
+	if sentences != nil {
+		return sentences, nil
+	}
+	for i, txt := range SplitSentences(parent.Content) {
+		sentences = append(sentences, &Sentence{ID: fmt.Sprintf("%d-%d", parent.RevID, i), Text: txt})
+	}
+	return sentences, err
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+// Cleaned revisions by revision ID, the most recent ones in memory.
+// Revisions of the run are expected before they're cleaned, so a child
+// waits for a parent that's still being cleaned instead of missing it.
+type textCache struct {
+	mu      sync.Mutex
+	size    int
+	lru     *list.List
+	entries map[int]*textEntry
+}
+
+type textEntry struct {
+	revID int
+	clean *RevisionClean
+	ok    bool
+	// Closed once the text is set, or the revision failed before it was
+	ready chan struct{}
+	elem  *list.Element
+}
+
+func newTextCache(size int) *textCache {
+	return &textCache{
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[int]*textEntry),
+	}
+}
+
+// Marks a revision of this run, gets wait for it from now on
+func (s *textCache) expect(revID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[revID]; !ok {
+		s.entries[revID] = &textEntry{revID: revID, ready: make(chan struct{})}
+	}
+}
+
+func (s *textCache) put(revID int, clean *RevisionClean) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[revID]
+	if !ok {
+		entry = &textEntry{revID: revID, ready: make(chan struct{})}
+		s.entries[revID] = entry
+	}
+	if entry.ok {
+		return
+	}
+	entry.clean, entry.ok = clean, true
+	close(entry.ready)
+	entry.elem = s.lru.PushFront(entry)
+
+	for s.lru.Len() > s.size {
+		oldest := s.lru.Remove(s.lru.Back()).(*textEntry)
+		delete(s.entries, oldest.revID)
+	}
+}
+
+// Unblocks whoever waits for a revision that won't be put, a no-op if it was
+func (s *textCache) release(revID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[revID]
+	if !ok || entry.ok {
+		return
+	}
+	delete(s.entries, revID)
+	close(entry.ready)
+}
+
+// found is false if the revision isn't part of the run, was evicted or failed
+func (s *textCache) get(ctx context.Context, revID int) (clean *RevisionClean, found bool, err error) {
+	s.mu.Lock()
+	entry, ok := s.entries[revID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, false, nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	case <-entry.ready:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry.ok && entry.elem != nil {
+		s.lru.MoveToFront(entry.elem)
+	}
+	return entry.clean, entry.ok, nil
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+// How much of two texts is the same words in the same order, 0 → nothing, 1 → identical.
+// Used to compare what two cleaners made of one revision.
+func WordSimilarity(a, b string) float64 {
+	aWords := strings.Fields(a)
+	bWords := strings.Fields(b)
+	if len(aWords)+len(bWords) == 0 {
+		return 1
+	}
+
+	dmp := diffmatchpatch.New()
+	// One word per "line", diffed as lines so every word is one token
+	aChars, bChars, lines := dmp.DiffLinesToChars(strings.Join(aWords, "\n")+"\n", strings.Join(bWords, "\n")+"\n")
+	diffs := dmp.DiffMain(aChars, bChars, false)
+
+	unchanged := 0
+	for _, d := range dmp.DiffCharsToLines(diffs, lines) {
+		if d.Type == diffmatchpatch.DiffEqual {
+			unchanged += strings.Count(d.Text, "\n")
+		}
+	}
+
+	return float64(2*unchanged) / float64(len(aWords)+len(bWords))
 }
 
 func symmetricChangeScore(i, d, u float64) float64 {
@@ -31,6 +31,17 @@ type RevisionMeta struct {
 	User      string    `json:"user"`
 	UserID    int       `json:"userid"`
 	Comment   string    `json:"comment"`
+	// Set by the API for logged out edits, temporary accounts and deleted user names
+	Anon       bool `json:"anon"`
+	Temp       bool `json:"temp"`
+	UserHidden bool `json:"userhidden"`
+	// Hex, empty if hidden or in an index scraped before it was asked for
+	SHA1 string   `json:"sha1"`
+	Tags []string `json:"tags"`
+
+	// Set when streamed from the scraper, saves reading it back from revs/.
+	// Dropped once the revision is cleaned.
+	Content *RevisionContent `json:"-"`
 }
 
 // User Data Response Batch
@@ -41,6 +52,30 @@ type UserData struct {
 	EditCount    int       `json:"editcount"`
 	Registration time.Time `json:"registration"`
 	Groups       []string  `json:"groups"`
+	// list=users knows no such user
+	Missing bool `json:"missing"`
+
+	// See editorKind, IPs are grouped by IPRange
+	Kind    string `json:"kind"`
+	IPRange string `json:"ipRange,omitempty"`
+
+	// When it was stored in the UserStore, zero for the made up profiles
+	FetchedAt time.Time `json:"fetchedAt,omitzero"`
+
+	// Changes of its groups from the rights log, oldest first, see groupsAt.
+	// RightsFetched tells an empty log from one that wasn't asked for.
+	Rights        []*RightsChange `json:"rights,omitempty"`
+	RightsFetched bool            `json:"rightsFetched,omitempty"`
+	// When asking for the rights log failed, it's asked for again by the next run
+	RightsFailedAt time.Time `json:"rightsFailedAt,omitzero"`
+}
+
+type RightsChange struct {
+	TimeStamp time.Time `json:"timestamp"`
+	OldGroups []string  `json:"oldGroups"`
+	NewGroups []string  `json:"newGroups"`
+	// Of the temporary groups among NewGroups
+	Expiries map[string]time.Time `json:"expiries,omitempty"`
 }
 
 type UserDataQuery struct {
@@ -52,11 +87,39 @@ type UserDataBatch struct {
 	Query         *UserDataQuery `json:"query"`
 }
 
+// Rights Log Response Batch
+
+type LogEvent struct {
+	TimeStamp time.Time `json:"timestamp"`
+	// rights or autopromote
+	Action string `json:"action"`
+	// Missing if the entry was hidden
+	Params *struct {
+		OldGroups   []string `json:"oldgroups"`
+		NewGroups   []string `json:"newgroups"`
+		NewMetadata []struct {
+			Group string `json:"group"`
+			// "infinity" or a timestamp
+			Expiry string `json:"expiry"`
+		} `json:"newmetadata"`
+	} `json:"params"`
+}
+
+type LogEventsData struct {
+	LogEvents []*LogEvent `json:"logevents"`
+}
+
+type LogEventsBatch struct {
+	Query *LogEventsData `json:"query"`
+}
+
 // Internal Processed Data
 
 type ProcessCtx struct {
 	Meta *RevisionMeta `json:"meta"`
 	User *UserData     `json:"user"`
+	// Edits of the user on the article before this one, as far back as the dump goes
+	ArticleEdits int `json:"articleEdits"`
 }
 
 type RevisionTags struct {
@@ -66,6 +129,30 @@ type RevisionTags struct {
 	IsContentExpansion bool `json:"isContentExpansion"`
 	IsCitationOnly     bool `json:"isCitationOnly"`
 	IsDefinitionChange bool `json:"isDefinitionChange"`
+	// The tags above that couldn't be told and are left false, e.g. without the parent
+	Unknown []string `json:"unknown,omitempty"`
+
+	// Reverts, see revertDetector
+	IsRevert bool `json:"isRevert"`
+	// identity, partial, claimed or unverified, see the Revert kinds
+	RevertKind string `json:"revertKind,omitempty"`
+	// The revision whose content was restored
+	RevertedTo int `json:"revertedTo,omitempty"`
+	// The revisions undone, oldest first
+	RevertedRevs []int `json:"revertedRevs,omitempty"`
+	IsReverted   bool  `json:"isReverted"`
+	// The revert that undid this one, 0 if unknown
+	RevertedBy int `json:"revertedBy,omitempty"`
+	// One of the last revertWindow revisions of the history when analysed, a revert
+	// coming with a later update can still tag it, so the next run analyses it again
+	RevertsPending bool `json:"revertsPending,omitempty"`
+	// What the revert tags are based on, e.g. "sha1 of 1003", "tag: mw-undo"
+	RevertEvidence []string `json:"revertEvidence,omitempty"`
+
+	// A tool or an unflagged bot, see automationDetector. IsBot is the bot flag.
+	IsAutomated bool `json:"isAutomated"`
+	// e.g. "comment: AWB", "cadence: 4 edits at most 8s apart"
+	AutomationEvidence []string `json:"automationEvidence,omitempty"`
 }
 
 type RevisionConfidence struct {
@@ -79,6 +166,32 @@ type RevisionConfidence struct {
 	Human int // 0–100
 }
 
+// What the confidences are made of
+type RevisionTrust struct {
+	// At the time of the edit, -1 if the registration is unknown
+	AccountAgeDays int `json:"accountAgeDays"`
+	// The current edit count, the API doesn't tell it at the time of the edit
+	EditCount    int `json:"editCount"`
+	ArticleEdits int `json:"articleEdits"`
+	// Held at the time of the edit, see groupsAt
+	Groups []string `json:"groups"`
+	// The groups are the current ones, not the ones held then, the rights log wasn't fetched
+	GroupsCurrent bool `json:"groupsCurrent,omitempty"`
+
+	// In the order they were applied
+	Factors []*TrustFactor `json:"factors"`
+}
+
+type TrustFactor struct {
+	// group:<name>, group:<name>:current when Groups are the current ones, tenure, editcount,
+	// article, or tool:<name>, cadence or repetitive, see automationDetector
+	Factor    string `json:"factor"`
+	Dimension string `json:"dimension"`
+	Weight    int    `json:"weight"`
+	// How much the dimension went up, less than the weight once it's high
+	Delta int `json:"delta"`
+}
+
 type RevisionDebug struct {
 	Errors   []error `json:"errors"`
 	Warnings []error `json:"warnings"`
@@ -98,12 +211,63 @@ type RevisionDiffs struct {
 	ChangeScore  int    `json:"changedScore"`
 	BalanceScore int    `json:"balanceScore"`
 	TypeOfEdit   string `json:"typeOfEdit"`
+
+	// Nil when the parent has no sections to compare with
+	Sections  *SectionDiffs  `json:"sections,omitempty"`
+	Sentences *SentenceDiffs `json:"sentences"`
+}
+
+type SectionDiffs struct {
+	Added     int `json:"added"`
+	Removed   int `json:"removed"`
+	Renamed   int `json:"renamed"`
+	Reordered int `json:"reordered"`
+
+	// Every section that changed, unchanged ones are left out
+	Changes []*SectionChange `json:"changes"`
+}
+
+type SectionChange struct {
+	// Headings from the lead, "History/Early days", the lead is ""
+	Path string `json:"path"`
+	// Set when the section had another path in the parent
+	OldPath string `json:"oldPath,omitempty"`
+	Heading string `json:"heading"`
+	Level   int    `json:"level"`
+	// added, removed, renamed, reordered or edited
+	Change   string `json:"change"`
+	Inserted int    `json:"inserted"`
+	Deleted  int    `json:"deleted"`
+}
+
+type SentenceDiffs struct {
+	Added     int `json:"added"`
+	Removed   int `json:"removed"`
+	Modified  int `json:"modified"`
+	Moved     int `json:"moved"`
+	Unchanged int `json:"unchanged"`
+
+	// Every sentence that changed, unchanged ones are left out
+	Changes []*SentenceChange `json:"changes"`
+}
+
+type SentenceChange struct {
+	// Follows the sentence through its edits, see Sentence
+	ID string `json:"id"`
+	// added, removed, modified or moved
+	Change string `json:"change"`
+	Text   string `json:"text"`
+	// The parent's version of a modified sentence
+	OldText string `json:"oldText,omitempty"`
+	// 0–100, for modified sentences
+	Similarity int `json:"similarity,omitempty"`
 }
 
 type RevisionAnalysis struct {
 	Process    *ProcessCtx         `json:"process"`
 	Tags       *RevisionTags       `json:"tags"`
 	Confidence *RevisionConfidence `json:"confidence"`
+	Trust      *RevisionTrust      `json:"trust"`
 	Diffs      *RevisionDiffs      `json:"diffs"`
 
 	Debug *RevisionDebug `json:"debug"`
@@ -117,6 +281,14 @@ type RevisionClean struct {
 	TimeStamp     time.Time `json:"timestamp"`
 	ContentFormat string    `json:"contentformat"`
 	Content       string    `json:"content"`
+	// Lead section at the root, offsets point into Content.
+	// Missing in revisions cleaned before sections were kept.
+	Sections *Section `json:"sections,omitempty"`
+
+	// Set by the differ, saved in sentences/
+	Sentences []*Sentence `json:"-"`
+	// What the classifier needs of what it was cleaned from, the wikitext itself is in revs/
+	Features *wikitextFeatures `json:"-"`
 }
 
 //
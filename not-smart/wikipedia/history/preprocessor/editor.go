@@ -0,0 +1,113 @@
+package preprocessor
+
+import (
+	"net/netip"
+	"regexp"
+	"slices"
+)
+
+// Who made an edit, only registered and temporary accounts are looked up with list=users
+const (
+	EditorRegistered = "registered"
+	// Logged out edits, the user name is the address
+	EditorIP = "ip"
+	// Accounts MediaWiki creates for logged out editors, named like ~2025-12345-67
+	EditorTemporary = "temporary"
+	// The user name was revision deleted or suppressed
+	EditorHidden = "hidden"
+	// An account list=users had nothing on, e.g. a deleted or renamed one
+	EditorUnknown = "unknown"
+)
+
+// Addresses of one editor usually stay within these, a home /24 or an IPv6 /64
+const (
+	ipv4RangeBits = 24
+	ipv6RangeBits = 64
+)
+
+var reTempName = regexp.MustCompile(`^~\d{4}-\d+`)
+
+// The kind of editor from the revision alone, no API call
+func editorKind(meta *RevisionMeta) string {
+	switch {
+	case meta.UserHidden || (meta.User == "" && meta.UserID == 0):
+		return EditorHidden
+	case meta.Temp || reTempName.MatchString(meta.User):
+		return EditorTemporary
+	case meta.Anon || meta.UserID == 0:
+		return EditorIP
+	default:
+		return EditorRegistered
+	}
+}
+
+// The range an address belongs to, e.g. 203.0.113.0/24, empty if it isn't one
+func ipRange(addr string) string {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return ""
+	}
+	bits := ipv6RangeBits
+	if ip.Unmap().Is4() {
+		ip, bits = ip.Unmap(), ipv4RangeBits
+	}
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
+
+// Whether the users of the revision have to be fetched
+func needsLookup(meta *RevisionMeta) bool {
+	kind := editorKind(meta)
+	return meta.UserID != 0 && (kind == EditorRegistered || kind == EditorTemporary)
+}
+
+// The profile of the editor of the revision. Registered and temporary accounts come
+// from the cache, the others are made up from the revision, a user that couldn't be
+// fetched is an unknown profile instead of an error.
+func (s *Preprocessor) userProfile(meta *RevisionMeta) *UserData {
+	kind := editorKind(meta)
+
+	if needsLookup(meta) {
+		user, ok := s.users.Get(meta.UserID)
+		if ok && !user.Missing {
+			return user
+		}
+		s.debugger.Debug("no user data for " + meta.User + ", unknown profile")
+		return &UserData{UserID: meta.UserID, Name: meta.User, Kind: EditorUnknown}
+	}
+
+	user := &UserData{Name: meta.User, Kind: kind}
+	if kind == EditorIP {
+		user.IPRange = ipRange(meta.User)
+		user.Groups = []string{string(TagAsterisk)}
+	}
+	return user
+}
+
+// Sets the kind of a fetched user, before it's shared
+func setFetchedKind(user *UserData) {
+	switch {
+	case user.Missing:
+		user.Kind = EditorUnknown
+	case reTempName.MatchString(user.Name) || slices.Contains(user.Groups, string(TagTemp)):
+		user.Kind = EditorTemporary
+	default:
+		user.Kind = EditorRegistered
+	}
+}
+
+// Whose edits count as the same editor's on an article, an IP's range groups its addresses.
+// Empty for hidden editors, they can't be told apart.
+func editorKey(user *UserData) string {
+	switch {
+	case user.Kind == EditorHidden:
+		return ""
+	case user.Kind == EditorIP && user.IPRange != "":
+		return user.IPRange
+	default:
+		return user.Name
+	}
+}
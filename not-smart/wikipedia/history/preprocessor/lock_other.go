@@ -0,0 +1,14 @@
+//go:build !unix
+
+package preprocessor
+
+import "os"
+
+// No flock, the store is never compacted since another run could be appending to it
+func tryLockExclusive(f *os.File) (bool, error) {
+	return false, nil
+}
+
+func lockShared(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package preprocessor
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Takes f for itself if no one else has it locked, see UserStore
+func tryLockExclusive(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Shares f with the other runs, waits while one of them has it for itself.
+// Turns an exclusive lock into a shared one.
+func lockShared(f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH)
+		if !errors.Is(err, syscall.EINTR) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,418 @@
+package preprocessor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"evolve/debugger"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cleans through a pool of pandoc-server processes on free ports.
+// Every server is supervised: one that crashes, or stops answering its health checks,
+// is killed and started again. Close stops them all and waits for the processes to exit.
+type PandocBackend struct {
+	serverCount int
+	// The slots nobody is using, each is in there once at most
+	idle       chan *pandocSlot
+	httpClient *http.Client
+	opts       PandocOptions
+
+	// Slots still supervised, Clean fails once there's none left
+	alive atomic.Int32
+	// Closed once every slot is given up
+	allDead chan struct{}
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	debugger *debugger.Debugger
+}
+
+// Where one server runs, the supervisor swaps the server when it restarts it
+type pandocSlot struct {
+	id int
+
+	mu sync.Mutex
+	// nil while it's restarting
+	srv *pandocServer
+	// Given up after too many failed starts
+	gone bool
+}
+
+func (s *pandocSlot) current() (*pandocServer, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.srv, s.gone
+}
+
+func (s *pandocSlot) set(srv *pandocServer, gone bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.srv, s.gone = srv, gone
+}
+
+type pandocServer struct {
+	url string
+	cmd *exec.Cmd
+	// Closed once the process exited
+	exited chan struct{}
+}
+
+func (s *pandocServer) dead() bool {
+	select {
+	case <-s.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// Kills the process and waits for it to be reaped
+func (s *pandocServer) stop() {
+	s.cmd.Process.Kill()
+	<-s.exited
+}
+
+// How the servers are started and watched, zero fields are the defaults
+type PandocOptions struct {
+	// How long a new server gets to answer, 10s
+	ReadyTimeout time.Duration
+	// Time between health checks, 5s, and how many can fail in a row before a restart, 3
+	HealthInterval time.Duration
+	HealthFailures int
+	// Starts that can fail in a row before a slot is given up, 5
+	MaxStartAttempts int
+	// The server and its first arguments, --port and --timeout are added, pandoc-server
+	Command []string
+}
+
+func (s *PandocOptions) withDefaults() PandocOptions {
+	opts := PandocOptions{}
+	if s != nil {
+		opts = *s
+	}
+	if opts.ReadyTimeout == 0 {
+		opts.ReadyTimeout = 10 * time.Second
+	}
+	if opts.HealthInterval == 0 {
+		opts.HealthInterval = 5 * time.Second
+	}
+	if opts.HealthFailures == 0 {
+		opts.HealthFailures = 3
+	}
+	if opts.MaxStartAttempts == 0 {
+		opts.MaxStartAttempts = 5
+	}
+	if len(opts.Command) == 0 {
+		opts.Command = []string{"pandoc-server"}
+	}
+	return opts
+}
+
+// Starts the servers, they're stopped by Close or once ctx is done.
+// Fails if one of them never comes up. opts can be nil for the defaults.
+func NewPandocBackend(ctx context.Context, servers int, opts *PandocOptions, debugger *debugger.Debugger) (*PandocBackend, error) {
+	pandocCtx, cancel := context.WithCancel(ctx)
+	s := &PandocBackend{
+		serverCount: servers,
+		idle:        make(chan *pandocSlot, servers),
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		opts:     opts.withDefaults(),
+		allDead:  make(chan struct{}),
+		ctx:      pandocCtx,
+		cancel:   cancel,
+		debugger: debugger,
+	}
+
+	slots := make([]*pandocSlot, 0, servers)
+	for id := range servers {
+		srv, err := s.start()
+		if err != nil {
+			cancel()
+			for _, slot := range slots {
+				slot.srv.stop()
+			}
+			return nil, err
+		}
+		slots = append(slots, &pandocSlot{id: id, srv: srv})
+	}
+
+	s.alive.Store(int32(servers))
+	for _, slot := range slots {
+		s.idle <- slot
+		s.wg.Add(1)
+		go s.supervise(slot)
+	}
+
+	return s, nil
+}
+
+// A free port, there's a small window where someone else can take it, the start then fails and is retried
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Starts a server and waits for it to answer
+func (s *PandocBackend) start() (*pandocServer, error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+
+	args := append(slices.Clone(s.opts.Command[1:]),
+		"--port", strconv.Itoa(port),
+		"--timeout", "30",
+	)
+	cmd := exec.Command(s.opts.Command[0], args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("pandoc-server start ERROR: %v", err)
+	}
+
+	srv := &pandocServer{
+		url:    fmt.Sprintf("http://127.0.0.1:%d", port),
+		cmd:    cmd,
+		exited: make(chan struct{}),
+	}
+	go func() {
+		cmd.Wait()
+		close(srv.exited)
+	}()
+
+	timeout := time.NewTimer(s.opts.ReadyTimeout)
+	defer timeout.Stop()
+	for !s.healthy(srv) {
+		select {
+		case <-s.ctx.Done():
+			srv.stop()
+			return nil, s.ctx.Err()
+		case <-srv.exited:
+			return nil, fmt.Errorf("pandoc-server on port %d exited before it was ready", port)
+		case <-timeout.C:
+			srv.stop()
+			return nil, fmt.Errorf("pandoc-server on port %d not ready after %v", port, s.opts.ReadyTimeout)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	return srv, nil
+}
+
+// Whether the server answers its version, anything but a 200 isn't a working server
+func (s *PandocBackend) healthy(srv *pandocServer) bool {
+	ctx, cancel := context.WithTimeout(s.ctx, 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.url+"/version", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// Watches one slot: restarts its server when it dies or fails its health checks, stops it on shutdown
+func (s *PandocBackend) supervise(slot *pandocSlot) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.opts.HealthInterval)
+	defer ticker.Stop()
+	failures := 0
+
+	for {
+		srv, _ := slot.current()
+		select {
+		case <-s.ctx.Done():
+			srv.stop()
+			return
+		case <-ticker.C:
+			if s.healthy(srv) {
+				failures = 0
+				continue
+			}
+			failures++
+			if failures < s.opts.HealthFailures {
+				continue
+			}
+			s.debugger.Print("\npandoc-server %d failed %d health checks, restarting it\n", slot.id, failures)
+			srv.stop()
+		case <-srv.exited:
+			s.debugger.Print("\npandoc-server %d exited, restarting it\n", slot.id)
+		}
+
+		failures = 0
+		slot.set(nil, false)
+		next, err := s.restart()
+		if err != nil {
+			if s.ctx.Err() == nil {
+				s.debugger.Print("\npandoc-server %d given up: %v\n", slot.id, err)
+			}
+			slot.set(nil, true)
+			if s.alive.Add(-1) == 0 {
+				close(s.allDead)
+			}
+			return
+		}
+		slot.set(next, false)
+	}
+}
+
+// Starts a server again, backing off between failed attempts
+func (s *PandocBackend) restart() (*pandocServer, error) {
+	backoff := 500 * time.Millisecond
+	var err error
+	for range s.opts.MaxStartAttempts {
+		var srv *pandocServer
+		if srv, err = s.start(); err == nil {
+			return srv, nil
+		}
+		select {
+		case <-s.ctx.Done():
+			return nil, s.ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 10*time.Second)
+	}
+	return nil, err
+}
+
+// A slot with a healthy server, waiting while they're all busy or restarting.
+// The slot goes back to idle once the request is done.
+func (s *PandocBackend) borrow(ctx context.Context) (*pandocSlot, *pandocServer, error) {
+	for {
+		var slot *pandocSlot
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-s.allDead:
+			return nil, nil, fmt.Errorf("every pandoc-server is down")
+		case slot = <-s.idle:
+		}
+
+		srv, gone := slot.current()
+		if gone {
+			// Never goes back to idle
+			continue
+		}
+		if srv == nil || srv.dead() {
+			// Restarting, try the others meanwhile
+			s.idle <- slot
+			select {
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			case <-time.After(50 * time.Millisecond):
+			}
+			continue
+		}
+		return slot, srv, nil
+	}
+}
+
+type PandocReq struct {
+	Text       string   `json:"text"`
+	From       string   `json:"from"`
+	To         string   `json:"to"`
+	Extensions []string `json:"extensions"`
+	Wrap       string   `json:"wrap"`
+}
+
+type PandocResp struct {
+	Output string `json:"output"`
+	Base64 bool   `json:"base64"`
+	// Message []struct {
+	// 	Verbosity string `json:"verbosity"`
+	// 	Message   string `json:"message"`
+	// } `json:"messages"`
+}
+
+func (s *PandocBackend) Clean(ctx context.Context, wikitext string) (string, error) {
+	pandocReq := PandocReq{
+		Text:       wikitext,
+		From:       "mediawiki",
+		To:         "plain",
+		Extensions: []string{"strip-comments"},
+		Wrap:       "none",
+	}
+	pandocReqBytes, err := json.Marshal(pandocReq)
+	if err != nil {
+		return "", fmt.Errorf("pandoc body marshall err: %v", err)
+	}
+
+	// A server dying mid request is retried on another one
+	var pandocRespBytes []byte
+	for attempt := 0; ; attempt++ {
+		slot, srv, err := s.borrow(ctx)
+		if err != nil {
+			return "", err
+		}
+		pandocRespBytes, err = s.post(ctx, srv.url, pandocReqBytes)
+		s.idle <- slot
+		if err == nil {
+			break
+		}
+		if !srv.dead() || attempt == 1 {
+			return "", err
+		}
+	}
+
+	pandocResp := new(PandocResp)
+	err = json.Unmarshal(pandocRespBytes, pandocResp)
+	if err != nil {
+		return "", fmt.Errorf("pandoc resp unmarshall err: %v", err)
+	}
+
+	return pandocResp.Output, nil
+}
+
+func (s *PandocBackend) post(ctx context.Context, url string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("pandoc returned non-200 ERROR: %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// Stops every server and waits for the processes to exit
+func (s *PandocBackend) Close() error {
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
@@ -0,0 +1,232 @@
+package preprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"evolve/debugger"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// What pandoc and the native cleaner make of a golden input share at least this much of their words
+const pandocMinSimilarity = 0.6
+
+func TestPandocSimilarity(t *testing.T) {
+	if _, err := exec.LookPath("pandoc-server"); err != nil {
+		t.Skip("pandoc-server is not installed")
+	}
+
+	inputs := make(map[string]string)
+	for _, input := range cleanGoldens(t) {
+		inputs[strings.TrimSuffix(filepath.Base(input), ".wiki")] = readFile(t, input)
+	}
+	// The debugger logs to the working directory
+	t.Chdir(t.TempDir())
+	debugger, err := debugger.NewDebugger()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	pandoc, err := NewPandocBackend(ctx, 1, nil, debugger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pandoc.Close()
+
+	for name, wikitext := range inputs {
+		pandocTxt, err := pandoc.Clean(ctx, wikitext)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		sim := WordSimilarity(WikitextToPlain(wikitext), pandocTxt)
+		t.Logf("%s: %.3f", name, sim)
+		if sim < pandocMinSimilarity {
+			t.Errorf("%s: similarity %.3f is below %.3f, pandoc made:\n%s", name, sim, pandocMinSimilarity, pandocTxt)
+		}
+	}
+}
+
+// Set in the environment of the fake servers the tests start, see TestFakePandocServer
+const (
+	// ok, broken never answers its health checks, flaky stops answering after the first one
+	fakePandocEnv = "EVOLVE_FAKE_PANDOC"
+	// The server exits at once if this file exists
+	fakePandocDownEnv = "EVOLVE_FAKE_PANDOC_DOWN"
+)
+
+// Not a test, the test binary runs itself as a fake pandoc-server with it
+func TestFakePandocServer(t *testing.T) {
+	mode := os.Getenv(fakePandocEnv)
+	if mode == "" {
+		t.Skip("run by the pandoc backend tests")
+	}
+	if _, err := os.Stat(os.Getenv(fakePandocDownEnv)); err == nil {
+		os.Exit(1)
+	}
+	args := flag.Args()
+	port := args[slices.Index(args, "--port")+1]
+
+	var checks atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /version", func(w http.ResponseWriter, r *http.Request) {
+		if mode == "broken" || (mode == "flaky" && checks.Add(1) > 1) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, "3.1")
+	})
+	mux.HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
+		req := new(PandocReq)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(&PandocResp{Output: strings.ToUpper(req.Text)})
+	})
+	http.ListenAndServe("127.0.0.1:"+port, mux)
+	os.Exit(1)
+}
+
+// A backend of fake servers in mode, see fakePandocEnv
+func fakePandoc(t *testing.T, mode string, servers int, opts *PandocOptions) (*PandocBackend, error) {
+	t.Helper()
+
+	t.Setenv(fakePandocEnv, mode)
+	t.Chdir(t.TempDir())
+	t.Setenv(fakePandocDownEnv, filepath.Join(t.TempDir(), "down"))
+	debugger, err := debugger.NewDebugger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Command = []string{os.Args[0], "-test.run=^TestFakePandocServer$", "--"}
+	pandoc, err := NewPandocBackend(context.Background(), servers, opts, debugger)
+	if pandoc != nil {
+		t.Cleanup(func() { pandoc.Close() })
+	}
+	return pandoc, err
+}
+
+// The server of the slot, the slot goes back to idle
+func slotServer(pandoc *PandocBackend) (*pandocSlot, *pandocServer) {
+	slot := <-pandoc.idle
+	srv, _ := slot.current()
+	pandoc.idle <- slot
+	return slot, srv
+}
+
+// Waits for the slot to run another server than srv
+func waitRestarted(t *testing.T, slot *pandocSlot, srv *pandocServer) {
+	t.Helper()
+
+	deadline := time.Now().Add(20 * time.Second)
+	for time.Now().Before(deadline) {
+		if next, _ := slot.current(); next != nil && next != srv && !next.dead() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("the server wasn't restarted")
+}
+
+func TestPandocBackendBorrow(t *testing.T) {
+	pandoc, err := fakePandoc(t, "ok", 2, &PandocOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// More requests at once than servers, they wait their turn
+	grp := errgroup.Group{}
+	for i := range 8 {
+		grp.Go(func() error {
+			txt := fmt.Sprintf("text %d", i)
+			out, err := pandoc.Clean(context.Background(), txt)
+			if err == nil && out != strings.ToUpper(txt) {
+				err = fmt.Errorf("cleaned %q to %q", txt, out)
+			}
+			return err
+		})
+	}
+	if err = grp.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if len(pandoc.idle) != 2 {
+		t.Errorf("%d slots idle, want 2", len(pandoc.idle))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for range 2 {
+		<-pandoc.idle
+	}
+	if _, _, err = pandoc.borrow(ctx); err != context.Canceled {
+		t.Errorf("borrow with every slot taken and a canceled context: %v", err)
+	}
+}
+
+// Answering isn't enough, the version has to come back
+func TestPandocBackendUnhealthy(t *testing.T) {
+	_, err := fakePandoc(t, "broken", 1, &PandocOptions{ReadyTimeout: 500 * time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "not ready") {
+		t.Fatalf("err %v, want not ready", err)
+	}
+}
+
+func TestPandocBackendRestartsExited(t *testing.T) {
+	pandoc, err := fakePandoc(t, "ok", 1, &PandocOptions{HealthInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slot, srv := slotServer(pandoc)
+	srv.stop()
+	waitRestarted(t, slot, srv)
+	if out, err := pandoc.Clean(context.Background(), "x"); err != nil || out != "X" {
+		t.Errorf("after the restart: %q, %v", out, err)
+	}
+}
+
+func TestPandocBackendRestartsUnhealthy(t *testing.T) {
+	pandoc, err := fakePandoc(t, "flaky", 1, &PandocOptions{HealthInterval: 20 * time.Millisecond, HealthFailures: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slot, srv := slotServer(pandoc)
+	waitRestarted(t, slot, srv)
+	if !srv.dead() {
+		t.Error("the unhealthy server is still running")
+	}
+}
+
+func TestPandocBackendGivesUp(t *testing.T) {
+	pandoc, err := fakePandoc(t, "ok", 1, &PandocOptions{HealthInterval: time.Hour, MaxStartAttempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every start from now on fails
+	if err = os.WriteFile(os.Getenv(fakePandocDownEnv), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, srv := slotServer(pandoc)
+	srv.stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	if _, err = pandoc.Clean(ctx, "x"); err == nil || !strings.Contains(err.Error(), "every pandoc-server is down") {
+		t.Errorf("err %v, want every pandoc-server is down", err)
+	}
+}
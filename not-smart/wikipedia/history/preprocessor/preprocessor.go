@@ -7,14 +7,9 @@ import (
 	"evolve/debugger"
 	"evolve/wikipedia/history"
 	"fmt"
-	"io"
 	"io/fs"
-	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
 	"sync/atomic"
 	"time"
 
@@ -23,16 +18,33 @@ import (
 
 type Metrics struct {
 	RevsParsed int `json:"Revs Parsed"`
+	// Already in 0analysis.jsonl from a previous run
+	RevsSkipped int `json:"Revs Skipped"`
 
 	UsersCacheFound int `json:"Users Cache Found"`
 	UsersFetched    int `json:"Users Fetched"`
+	// Stored but past the ttl, fetched again
+	UsersRefreshed    int `json:"Users Refreshed"`
+	RightsLogsFetched int `json:"Rights Logs Fetched"`
+
+	RevsProcessed    int `json:"Revs Processed"`
+	RevUsersAnalysed int `json:"Rev Users Analysed"`
+	RevsCleaned      int `json:"Revs Cleaned"`
+	RevsDiffed       int `json:"Revs Diffed"`
+	RevsClassified   int `json:"Revs Classified"`
+	// Tagged by the automation detector
+	RevsAutomated int       `json:"Revs Automated"`
+	ProcessStart  time.Time `json:"Process Start"`
+	ProcessEnd    time.Time `json:"Process End"`
+}
 
-	RevsProcessed    int       `json:"Revs Processed"`
-	RevUsersAnalysed int       `json:"Rev Users Analysed"`
-	RevsCleaned      int       `json:"Revs Cleaned"`
-	RevsDiffed       int       `json:"Revs Diffed"`
-	ProcessStart     time.Time `json:"Process Start"`
-	ProcessEnd       time.Time `json:"Process End"`
+// Revisions through each step of analyzeRev. The workers count them here,
+// the metrics get them once the workers are done.
+type stageCounts struct {
+	usersAnalysed atomic.Int64
+	cleaned       atomic.Int64
+	diffed        atomic.Int64
+	classified    atomic.Int64
 }
 
 type Commons struct {
@@ -42,43 +54,69 @@ type Commons struct {
 	dumpDir  string
 }
 
+// Options of a preprocessor run, zero values fall back to the defaults
+type Options struct {
+	// Revisions analysed concurrently
+	Workers int
+	// CleanerNative or CleanerPandoc
+	Cleaner string
+	// pandoc-server processes the pandoc cleaner round-robins over
+	PandocServers int
+	// How groups, tenure and automation weigh on the confidences, DefaultRules if nil
+	Rules *Rules
+	// Shared by the titles of a run, the caller closes it. If nil the run opens the
+	// UserStoreFile of the wiki's dump directory with DefaultUserTTL and closes it.
+	Users *UserStore
+}
+
 type Preprocessor struct {
-	ROOT_URL string
-	BASE_URL string
-	USER_URL *url.URL
+	opts *Options
+
+	// list=users query, the user IDs' are filled per batch
+	userQuery *history.UsersQuery
 
 	ctx    context.Context
 	cancel context.CancelFunc
 	grp    *errgroup.Group
 	grpctx context.Context
+	// Closed once every stage returned, err is the first error of the group
+	done chan struct{}
+	err  error
 
-	// The path of the file that has the ids' metadata
+	// The path of the file that has the ids' metadata, or the channel they're streamed on
 	inpFilePath   string
+	metaChan      chan *RevisionMeta
 	dumpDir       string
 	analysisFName string
 
 	rawRevsDumpDir string
 	cleanDumpDir   string
+	// Sentences and their IDs, see Sentence
+	sentencesDumpDir string
 
-	//
+	// Revisions go through the users stage, which forwards them once their user is cached
 	fetchUsersChan chan *RevisionMeta
 	processRevChan chan *RevisionMeta
 
-	//User cache file path
-	userCacheFPath string
-	// Caches userID to UserData
-	userCache map[int]*UserData
-	//
+	// Users of the wiki, see UserStore. ownUsers if the run opened it.
+	users    *UserStore
+	ownUsers bool
+	// Per article user cache of older runs, imported into the store
+	legacyUsersFPath string
 
+	client   *history.Client
 	metrics  *Metrics
 	debugger *debugger.Debugger
+	// Counted by the workers, see stageCounts
+	stages stageCounts
 
-	Cleaner *Cleaner
-	Differ  *Differ
-	User    *UserAnalyzer
+	Cleaner    *Cleaner
+	Differ     *Differ
+	Classifier *Classifier
+	User       *UserAnalyzer
 }
 
-func NewWikiPreprocessor(inpFile string, metaChan chan *RevisionMeta, rootDumpDir string, debugger *debugger.Debugger) (*Preprocessor, error) {
+func NewWikiPreprocessor(inpFile string, metaChan chan *RevisionMeta, rootDumpDir string, opts *Options, client *history.Client, debugger *debugger.Debugger) (*Preprocessor, error) {
 	if inpFile != "" && metaChan != nil {
 		return nil, fmt.Errorf("either file or metachan is to be provided")
 	}
@@ -86,29 +124,53 @@ func NewWikiPreprocessor(inpFile string, metaChan chan *RevisionMeta, rootDumpDi
 		return nil, fmt.Errorf("no file path and metachan provided")
 	}
 
+	if opts == nil {
+		opts = new(Options)
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 40
+	}
+	if opts.Cleaner == "" {
+		opts.Cleaner = CleanerNative
+	}
+	if opts.PandocServers <= 0 {
+		opts.PandocServers = 3
+	}
+	if opts.Rules == nil {
+		opts.Rules = DefaultRules()
+	}
+
 	p := &Preprocessor{
-		ROOT_URL:       history.ROOT_URL,
+		opts:           opts,
 		inpFilePath:    inpFile,
+		metaChan:       metaChan,
 		dumpDir:        rootDumpDir,
 		fetchUsersChan: make(chan *RevisionMeta, 10),
 		processRevChan: make(chan *RevisionMeta, 10),
-		userCache:      make(map[int]*UserData),
+		client:         client,
 		metrics:        new(Metrics),
 		debugger:       debugger,
 	}
 
-	var err error
-	if p.BASE_URL, err = p.prepareRootURLs(); err != nil {
-		return nil, err
-	}
-	if p.USER_URL, err = p.prepareUserURL(); err != nil {
-		return nil, err
+	p.userQuery = &history.UsersQuery{
+		Props: []string{"groups", "editcount", "registration"},
 	}
 
-	p.analysisFName = filepath.Join(p.dumpDir, "0analysis.json")
+	p.analysisFName = filepath.Join(p.dumpDir, "0analysis.jsonl")
 	p.rawRevsDumpDir = filepath.Join(p.dumpDir, "revs")
 	p.cleanDumpDir = filepath.Join(p.dumpDir, "clean")
-	p.userCacheFPath = filepath.Join(p.dumpDir, "0users.json")
+	p.sentencesDumpDir = filepath.Join(p.dumpDir, "sentences")
+	p.legacyUsersFPath = filepath.Join(p.dumpDir, "0users.json")
+
+	p.users = opts.Users
+	if p.users == nil {
+		// The dump directory of the title is in the wiki's
+		store, err := OpenUserStore(filepath.Join(filepath.Dir(p.dumpDir), UserStoreFile), DefaultUserTTL, false)
+		if err != nil {
+			return nil, fmt.Errorf("user store: %w", err)
+		}
+		p.users, p.ownUsers = store, true
+	}
 
 	return p, nil
 }
@@ -120,12 +182,24 @@ func (s *Preprocessor) initStages() error {
 		debugger: s.debugger,
 		dumpDir:  s.dumpDir,
 	}
-	var err error
-	s.Cleaner, err = NewCleaner(commons, s.rawRevsDumpDir, s.cleanDumpDir)
-	s.Differ = NewDiffer(commons)
-	s.User = NewUserAnalyzer(commons)
+	backend, err := NewCleanBackend(s.ctx, s.opts.Cleaner, s.opts.PandocServers, s.debugger)
+	if err != nil {
+		return err
+	}
+	s.Cleaner, err = NewCleaner(commons, s.rawRevsDumpDir, s.cleanDumpDir, backend)
+	if err != nil {
+		backend.Close()
+		return err
+	}
+	s.Differ, err = NewDiffer(commons, s.cleanDumpDir, s.sentencesDumpDir)
+	if err != nil {
+		s.Cleaner.Close()
+		return err
+	}
+	s.Classifier = NewClassifier(commons, s.rawRevsDumpDir)
+	s.User = NewUserAnalyzer(commons, s.opts.Rules)
 
-	return err
+	return nil
 }
 
 func (s *Preprocessor) Run() error {
@@ -137,7 +211,11 @@ func (s *Preprocessor) Run() error {
 		return err
 	}
 
-	s.initStages()
+	if err := s.initStages(); err != nil {
+		s.cancel()
+		s.closeUsers()
+		return err
+	}
 
 	s.grp.Go(func() error {
 		return stopped("consumeForUsers", s.consumeForUsers())
@@ -151,29 +229,48 @@ func (s *Preprocessor) Run() error {
 		s.grp.Go(func() error {
 			return stopped("startRevsFile", s.startRevsFile())
 		})
+	} else {
+		s.grp.Go(func() error {
+			return stopped("startRevsChan", s.startRevsChan())
+		})
 	}
 
-	s.grp.Go(func() error {
+	// A failing stage cancels the rest, the context is also what stops the pandoc servers
+	s.done = make(chan struct{})
+	go func() {
 		<-s.grpctx.Done()
-		fmt.Println("GOING TO CANCEL THE CONTEXT")
 		s.cancel()
-		return nil
-	})
+	}()
+	go func() {
+		s.err = s.grp.Wait()
+		s.cancel()
+		s.Cleaner.Close()
+		if err := s.closeUsers(); err != nil && s.err == nil {
+			s.err = err
+		}
+		s.debugger.Print("\nALL PROCESSES HAVE STOPPED.\n")
+		close(s.done)
+	}()
 
 	return nil
 }
 
-func (s *Preprocessor) Stop() error {
-	s.cancel()
+// Done is closed once every revision is processed, or the run was stopped
+func (s *Preprocessor) Done() <-chan struct{} {
+	return s.done
+}
 
-	err := s.grp.Wait()
-	if err != nil {
-		return err
-	}
+// Wait blocks until the run is over and returns its first error
+func (s *Preprocessor) Wait() error {
+	<-s.done
+	return s.err
+}
 
-	s.debugger.Print("\nALL PROCESSES HAVE STOPPED.\n")
+// Stop cancels the run early
+func (s *Preprocessor) Stop() error {
+	s.cancel()
 
-	return nil
+	return s.Wait()
 }
 
 func (s *Preprocessor) PrintMetrics() error {
@@ -191,6 +288,7 @@ func (s *Preprocessor) PrintMetrics() error {
 	fmt.Printf("\n AnalyzeUser: %d\n", auTimes.Load())
 	fmt.Printf("\n CleanRev: %d\n", crTimes.Load())
 	fmt.Printf("\n AnalyzeDiff: %d\n", adTimes.Load())
+	fmt.Printf("\n ClassifyRev: %d\n", ccTimes.Load())
 	fmt.Printf("\n Bots: %d\n", bots.Load())
 
 	return nil
@@ -210,7 +308,8 @@ func (s *Preprocessor) startRevsFile() error {
 		return fmt.Errorf("empty file")
 	}
 
-	fmt.Println("Pushing Revisions for users")
+	// The index is newest first
+	fmt.Println("Pushing Revisions")
 outer:
 	for i := len(revisions) - 1; i >= 0; i-- {
 		select {
@@ -221,210 +320,258 @@ outer:
 	}
 	close(s.fetchUsersChan)
 
-	fmt.Println("Pushing Revisions for processing")
-exit:
-	for i := len(revisions) - 1; i >= 0; i-- {
+	return nil
+}
+
+// Revisions as the scraper saves them, oldest first. Whoever sends closes metaChan.
+func (s *Preprocessor) startRevsChan() error {
+	defer close(s.fetchUsersChan)
+
+	for {
 		select {
 		case <-s.ctx.Done():
-			break exit
-		case s.processRevChan <- revisions[i]:
+			return nil
+		case meta, ok := <-s.metaChan:
+			if !ok {
+				return nil
+			}
+			s.metrics.RevsParsed += 1
+			select {
+			case <-s.ctx.Done():
+				return nil
+			case s.fetchUsersChan <- meta:
+			}
 		}
 	}
-	close(s.processRevChan)
-
-	return nil
 }
 
 // >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
 
-func (s *Preprocessor) prepareUsersCache() error {
-	data, err := os.ReadFile(s.userCacheFPath)
+// Moves the 0users.json of a run before the store into it, stale as of when it was written
+func (s *Preprocessor) importLegacyUsers() error {
+	info, err := os.Stat(s.legacyUsersFPath)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			return nil
 		}
 		return err
 	}
-
-	if len(data) == 0 {
-		return nil
+	data, err := os.ReadFile(s.legacyUsersFPath)
+	if err != nil || len(data) == 0 {
+		return err
 	}
 
-	return json.Unmarshal(data, &s.userCache)
+	legacy := make(map[int]*UserData)
+	if err = json.Unmarshal(data, &legacy); err != nil {
+		return fmt.Errorf("%s: %v", s.legacyUsersFPath, err)
+	}
+	users := make([]*UserData, 0, len(legacy))
+	for _, user := range legacy {
+		if user.Kind == "" {
+			setFetchedKind(user)
+		}
+		user.FetchedAt = info.ModTime().UTC()
+		users = append(users, user)
+	}
+	return s.users.Import(users)
 }
 
-func (s *Preprocessor) saveUsersCache() error {
-	data, err := json.MarshalIndent(s.userCache, "", "  ")
-	if err != nil {
-		return err
+func (s *Preprocessor) closeUsers() error {
+	if !s.ownUsers {
+		return nil
 	}
-
-	return os.WriteFile(s.userCacheFPath, data, 0700)
+	return s.users.Close()
 }
 
+// Revisions wait here until their user is cached, then go on to processing in the order they came.
+// Users are fetched 49 at a time, or sooner when no revision came for a moment, so a slow stream doesn't stall.
 func (s *Preprocessor) consumeForUsers() error {
-	if err := s.prepareUsersCache(); err != nil {
+	defer close(s.processRevChan)
+
+	if err := s.importLegacyUsers(); err != nil {
 		return err
 	}
-	s.debugger.Print("\nCURRENT USER CACHE LEN: %d\n", len(s.userCache))
-	s.metrics.UsersCacheFound = len(s.userCache)
-
-	urlChan := make(chan string, 1)
-	s.grp.Go(func() error {
-		return s.fetchUsersData(urlChan)
-	})
+	s.debugger.Print("\nCURRENT USER CACHE LEN: %d\n", s.users.Len())
+	s.metrics.UsersCacheFound = s.users.Len()
 
 	usersBatchLim := 49
+	pendingLim := 500
+	idleWait := 500 * time.Millisecond
+
 	usersBatchMap := make(map[int]struct{})
-	ususersParam := "ususerids"
+	pending := make([]*RevisionMeta, 0)
 
-	url := *s.USER_URL
-	params := url.Query()
-	getURL := func() string {
-		var sb strings.Builder
-		first := true
+	getQuery := func() *history.UsersQuery {
+		query := *s.userQuery
+		query.UserIDs = make([]int, 0, len(usersBatchMap))
 		for id := range usersBatchMap {
-			if !first {
-				sb.WriteByte('|')
-			} else {
-				first = false
-			}
-			sb.WriteString(strconv.Itoa(id))
+			query.UserIDs = append(query.UserIDs, id)
 		}
-		params.Set(ususersParam, sb.String())
-		url.RawQuery = params.Encode()
-
-		return url.String()
+		return &query
 	}
 
-	// flushes the buffer, fetches the results and caches them
-	flushUserBatch := func() {
-		if len(usersBatchMap) == 0 {
-			return
-		}
-		select {
-		case <-s.ctx.Done():
-			return
-		case urlChan <- getURL():
+	// fetches the buffered users, caches them and lets their revisions through
+	flush := func() error {
+		if len(usersBatchMap) > 0 {
+			if err := s.fetchUsersData(getQuery()); err != nil {
+				return err
+			}
 			clear(usersBatchMap)
 		}
+		for _, meta := range pending {
+			select {
+			case <-s.ctx.Done():
+				return nil
+			case s.processRevChan <- meta:
+			}
+		}
+		pending = pending[:0]
+		return nil
 	}
 
 outer:
 	for {
+		var idle <-chan time.Time
+		if len(pending) > 0 {
+			idle = time.After(idleWait)
+		}
+
 		select {
 		case <-s.ctx.Done():
 			break outer
+		case <-idle:
+			if err := flush(); err != nil {
+				return err
+			}
 		case meta, ok := <-s.fetchUsersChan:
 			if !ok {
 				break outer
 			}
-			_, cached := s.userCache[meta.UserID]
-			_, exists := usersBatchMap[meta.UserID]
-			if !cached && !exists {
-				usersBatchMap[meta.UserID] = struct{}{}
+			// IPs, hidden users and the like have nothing to fetch
+			if needsLookup(meta) && !s.users.Fresh(meta.UserID) {
+				if _, ok := usersBatchMap[meta.UserID]; !ok {
+					if _, stored := s.users.Get(meta.UserID); stored {
+						s.metrics.UsersRefreshed += 1
+					}
+					usersBatchMap[meta.UserID] = struct{}{}
+				}
 			}
+			pending = append(pending, meta)
 
-			if len(usersBatchMap) == usersBatchLim {
-				flushUserBatch()
+			if len(usersBatchMap) == usersBatchLim || len(pending) == pendingLim {
+				if err := flush(); err != nil {
+					return err
+				}
 			}
 		}
 	}
 
-	if len(usersBatchMap) > 0 {
-		flushUserBatch()
-	}
-
-	close(urlChan)
-
-	if err := s.saveUsersCache(); err != nil {
-		return err
+	if s.ctx.Err() == nil {
+		return flush()
 	}
-
 	return nil
 }
 
-func (s *Preprocessor) fetchUsersData(urlChan chan string) error {
-	client := http.Client{}
-	rate := 3                     // Max number of requests in 1 second
-	timeBtn := int64(1000 / rate) // Minimum time gap between 2 requests to maintain rate
-	lastReq := int64(0)           // UnixMilliseconds of last request
-
-outer:
-	for {
-		select {
-		case <-s.ctx.Done():
-			break outer
-		case url, ok := <-urlChan:
-			if !ok {
-				break outer
-			}
-
-			req, err := http.NewRequest("GET", url, nil)
-			if err != nil {
-				return err
-			}
-			req.Header.Set("User-Agent", history.USER_AGENT)
-
-			diff := time.Now().UnixMilli() - lastReq
-			if diff < timeBtn {
-				time.Sleep(time.Duration(timeBtn-diff) * time.Millisecond)
-			}
-
-			lastReq = time.Now().UnixMilli()
-			resp, err := client.Do(req)
-			if err != nil {
-				return err
-			}
-
-			d := fmt.Sprintf("Fetching user data: %s : %dms : %d\n", url, time.Now().UnixMilli()-lastReq, resp.StatusCode)
-			s.debugger.Debug(d)
-
-			// TODO: proper fallback and wait
-			if resp.StatusCode != 200 {
-				return fmt.Errorf("status non-200")
-			}
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return err
-			}
+func (s *Preprocessor) fetchUsersData(query *history.UsersQuery) error {
+	// The client keeps the rate limit shared with the scraper
+	start := time.Now().UnixMilli()
+	batch := new(UserDataBatch)
+	_, err := s.client.Query(s.ctx, query, nil, batch)
+	if err != nil {
+		if s.ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("user data fetch error: %w", err)
+	}
 
-			batch := new(UserDataBatch)
-			err = json.Unmarshal(body, batch)
-			if err != nil {
-				return err
-			}
+	d := fmt.Sprintf("Fetching user data: %d users : %dms\n", len(query.UserIDs), time.Now().UnixMilli()-start)
+	s.debugger.Debug(d)
 
-			if batch.Query == nil || batch.Query.Users == nil || len(batch.Query.Users) == 0 {
-				return fmt.Errorf("users is empty")
-			}
+	// Their revisions get an unknown profile, see userProfile
+	if batch.Query == nil || len(batch.Query.Users) == 0 {
+		s.debugger.Debug(fmt.Sprintf("no users returned for %v\n", query.UserIDs))
+		return nil
+	}
 
-			// set all fetched users in the cache
-			for _, user := range batch.Query.Users {
-				s.metrics.UsersFetched += 1
-				s.userCache[user.UserID] = user
+	// Missing users are stored too, they aren't asked for again until they're stale
+	for _, user := range batch.Query.Users {
+		s.metrics.UsersFetched += 1
+		setFetchedKind(user)
+		if !needsRights(user) {
+			continue
+		}
+		// One request per user, letitle takes a single title.
+		// Without it the current groups are used, and it's asked for again next run.
+		if err := s.fetchRights(user); err != nil {
+			if s.ctx.Err() != nil {
+				return nil
 			}
+			user.RightsFailedAt = time.Now().UTC()
+			s.debugger.Debug(err.Error() + "\n")
+			continue
 		}
+		s.metrics.RightsLogsFetched += 1
 	}
-
-	return nil
+	return s.users.Put(batch.Query.Users)
 }
 
 // >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
 
-func (s *Preprocessor) consumeForProcess() error {
-	revAnalyses := make([]*RevisionAnalysis, 0)
+func (s *Preprocessor) consumeForProcess() (err error) {
+	analyses, err := OpenAnalysisWriter(s.analysisFName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := analyses.Close(); err == nil {
+			err = closeErr
+		}
+	}()
 
 	parallel := func(revCtx *RevisionAnalysis) error {
 		if err := s.analyzeRev(revCtx); err != nil {
+			// Cut short by a stop, it's redone on the next run
+			if s.ctx.Err() != nil {
+				return nil
+			}
 			revCtx.Debug.Errors = append(revCtx.Debug.Errors, err)
 		}
-		return nil
+		return analyses.Write(revCtx)
 	}
 
 	grp := errgroup.Group{}
-	grp.SetLimit(40)
+	grp.SetLimit(s.opts.Workers)
+
+	dispatch := func(revCtx *RevisionAnalysis) {
+		if analyses.Has(revCtx.Process.Meta.RevID) {
+			s.metrics.RevsSkipped += 1
+			return
+		}
+
+		// Before the worker starts, so its children know to wait for its text
+		s.Differ.texts.expect(revCtx.Process.Meta.RevID)
+		grp.Go(func() error {
+			return parallel(revCtx)
+		})
+
+		s.metrics.RevsProcessed += 1
+		if revCtx.Tags.IsAutomated {
+			s.metrics.RevsAutomated += 1
+		}
 
+		if s.metrics.RevsProcessed%100 == 0 {
+			s.debugger.Print("\nRevs Processed: %d", s.metrics.RevsProcessed)
+		}
+	}
+
+	// Every revision goes through these, the skipped ones too, their hashes and timing are needed
+	reverts := newRevertDetector(s.Cleaner.wikitext)
+	automation, err := newAutomationDetector(s.opts.Rules)
+	if err != nil {
+		return err
+	}
+	// Edits so far per editor, see editorKey
+	articleEdits := make(map[string]int)
 	first := true
 
 outer:
@@ -440,41 +587,45 @@ outer:
 				s.metrics.ProcessStart = time.Now().UTC()
 				first = false
 			}
-
-			userData, exists := s.userCache[meta.UserID]
-			if !exists {
-				return fmt.Errorf("user data cache wasn't found: %d", meta.UserID)
-			}
+			userData := s.userProfile(meta)
+			editor := editorKey(userData)
 
 			revCtx := &RevisionAnalysis{
 				Process: &ProcessCtx{
-					Meta: meta,
-					User: userData,
+					Meta:         meta,
+					User:         userData,
+					ArticleEdits: articleEdits[editor],
 				},
 				Tags:       new(RevisionTags),
 				Confidence: new(RevisionConfidence),
+				Trust:      new(RevisionTrust),
 				Diffs:      new(RevisionDiffs),
 				Debug:      new(RevisionDebug),
 			}
-			revAnalyses = append(revAnalyses, revCtx)
-
-			grp.Go(func() error {
-				return parallel(revCtx)
-			})
 
-			s.metrics.RevsProcessed += 1
+			if editor != "" {
+				articleEdits[editor]++
+			}
+			automation.detect(revCtx, editor)
 
-			if s.metrics.RevsProcessed%100 == 0 {
-				s.debugger.Print("\nRevs Processed: %d", s.metrics.RevsProcessed)
+			for _, released := range reverts.push(revCtx) {
+				dispatch(released)
 			}
 		}
 	}
-
-	data, err := json.MarshalIndent(revAnalyses, "", "  ")
-	if err != nil {
-		return err
+	if s.ctx.Err() == nil {
+		for _, released := range reverts.flush() {
+			released.Tags.RevertsPending = true
+			dispatch(released)
+		}
 	}
-	err = os.WriteFile(s.analysisFName, data, 0700)
+
+	// Let the in-flight revisions finish before closing the file
+	err = grp.Wait()
+	s.metrics.RevUsersAnalysed = int(s.stages.usersAnalysed.Load())
+	s.metrics.RevsCleaned = int(s.stages.cleaned.Load())
+	s.metrics.RevsDiffed = int(s.stages.diffed.Load())
+	s.metrics.RevsClassified = int(s.stages.classified.Load())
 	if err != nil {
 		return err
 	}
@@ -487,18 +638,20 @@ outer:
 var auTimes atomic.Int64
 var crTimes atomic.Int64
 var adTimes atomic.Int64
+var ccTimes atomic.Int64
 
 var bots atomic.Int64
 
 func (s *Preprocessor) analyzeRev(r *RevisionAnalysis) error {
 	var err error
+	defer s.Differ.texts.release(r.Process.Meta.RevID)
 
 	t1 := time.Now()
 	err = s.User.analyzeUser(r)
 	if err != nil {
 		return err
 	}
-	s.metrics.RevUsersAnalysed++
+	s.stages.usersAnalysed.Add(1)
 	auTimes.Add(time.Since(t1).Milliseconds())
 
 	t2 := time.Now()
@@ -507,16 +660,24 @@ func (s *Preprocessor) analyzeRev(r *RevisionAnalysis) error {
 		fmt.Printf("ERROR: %v\n", err)
 		return err
 	}
-	s.metrics.RevsCleaned++
+	s.stages.cleaned.Add(1)
 	crTimes.Add(time.Since(t2).Milliseconds())
 
 	t3 := time.Now()
-	err = s.Differ.analyzeDiff(r, revClean)
+	parent, err := s.Differ.analyzeDiff(r, revClean)
 	if err != nil {
 		return err
 	}
-	s.metrics.RevsDiffed++
+	s.stages.diffed.Add(1)
 	adTimes.Add(time.Since(t3).Milliseconds())
 
+	t4 := time.Now()
+	err = s.Classifier.classifyRev(r, revClean, parent)
+	if err != nil {
+		return err
+	}
+	s.stages.classified.Add(1)
+	ccTimes.Add(time.Since(t4).Milliseconds())
+
 	return nil
 }
@@ -0,0 +1,341 @@
+package preprocessor
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// How many revisions back reverted revisions are marked, MediaWiki's $wgRevertedTagMaxDepth.
+// Revisions are held back this long before they're analysed, so a later revert can still tag them.
+const revertWindow = 15
+
+// Kinds of reverts
+const (
+	// The content is the same as an earlier revision's, by sha1
+	RevertIdentity = "identity"
+	// It puts back lines a recent revision removed or takes out lines one added, see undone
+	RevertPartial = "partial"
+	// The comment or change tags say it's a revert, but the content doesn't show it
+	RevertClaimed = "claimed"
+	// The comment or change tags say it's a revert, there's neither a hash nor the content to check it
+	RevertUnverified = "unverified"
+)
+
+// A partial revert undoes at least this many bytes of lines of a revision...
+const partialRevertMinBytes = 20
+
+// ...and what it undoes is at least this much of what it changes, a rewrite
+// that happens to put back a line isn't a revert
+const partialRevertMinShare = 0.5
+
+// Change tags MediaWiki puts on reverts and on reverted edits
+const (
+	tagUndo         = "mw-undo"
+	tagRollback     = "mw-rollback"
+	tagManualRevert = "mw-manual-revert"
+	tagReverted     = "mw-reverted"
+)
+
+var (
+	// Undid revision 123 by [[Special:Contributions/X|X]]
+	reUndo = regexp.MustCompile(`(?i)\bundid revision (\d+)`)
+	// Restored revision 123 by X
+	reRestored = regexp.MustCompile(`(?i)\brestored revision (\d+)`)
+	// Reverted edits by [[Special:Contributions/X|X]], Twinkle's Reverted 2 (good faith) edits by X
+	reRollback = regexp.MustCompile(`(?i)\breverted (?:\d+ )?(?:good faith )?edits? by \[*(?:Special:Contributions/|User:)?([^|\]\(]+?)\s*(?:\||\]|\(|$)`)
+	// Everything else that calls itself a revert, ClueBot NG's Reverting possible vandalism, rv, rvv
+	reRevert = regexp.MustCompile(`(?i)^(reverting|reverted|revert|rvv?)\b`)
+)
+
+// Tags reverts and reverted revisions. Revisions go through it oldest first and come
+// out revertWindow revisions later, once no revert can tag them anymore.
+type revertDetector struct {
+	// Not released yet, oldest first
+	window []*RevisionAnalysis
+
+	// The wikitext of a revision, false if it can't be read
+	wikitext func(meta *RevisionMeta) (string, bool)
+	// Of the last revertWindow+1 revisions, by revid
+	lines map[int]*revisionLines
+
+	// Every revision seen, oldest first, and where
+	order []int
+	pos   map[int]int
+	// Index in order of the newest revision with each sha1
+	hashes map[string]int
+}
+
+// The lines of a revision and the ones it added and removed from its parent's, as counts
+// since a line can be there several times. nil added and removed if the parent's unknown.
+type revisionLines struct {
+	lines   map[string]int
+	added   map[string]int
+	removed map[string]int
+}
+
+func newRevertDetector(wikitext func(meta *RevisionMeta) (string, bool)) *revertDetector {
+	return &revertDetector{
+		wikitext: wikitext,
+		lines:    make(map[int]*revisionLines),
+		pos:      make(map[int]int),
+		hashes:   make(map[string]int),
+	}
+}
+
+// Adds the next revision, returns the ones that left the window
+func (s *revertDetector) push(rc *RevisionAnalysis) []*RevisionAnalysis {
+	s.detect(rc)
+	s.window = append(s.window, rc)
+	if len(s.window) <= revertWindow {
+		return nil
+	}
+	released := s.window[:len(s.window)-revertWindow]
+	s.window = slices.Clone(s.window[len(s.window)-revertWindow:])
+	return released
+}
+
+// Once there are no revisions left
+func (s *revertDetector) flush() []*RevisionAnalysis {
+	released := s.window
+	s.window = nil
+	return released
+}
+
+func (s *revertDetector) detect(rc *RevisionAnalysis) {
+	meta := rc.Process.Meta
+	tags := rc.Tags
+
+	n := len(s.order)
+	s.order = append(s.order, meta.RevID)
+	s.pos[meta.RevID] = n
+
+	if slices.Contains(meta.Tags, tagReverted) {
+		tags.IsReverted = true
+		tags.RevertEvidence = append(tags.RevertEvidence, "tag: "+tagReverted)
+	}
+
+	changes := s.changes(meta)
+	if n > revertWindow {
+		delete(s.lines, s.order[n-revertWindow-1])
+	}
+
+	claimed, reverted, restored := s.fromComment(rc)
+	for _, tag := range []string{tagUndo, tagRollback, tagManualRevert} {
+		if slices.Contains(meta.Tags, tag) {
+			claimed = true
+			tags.RevertEvidence = append(tags.RevertEvidence, "tag: "+tag)
+		}
+	}
+
+	// Same content as the parent is a null edit, not a revert
+	if meta.SHA1 != "" {
+		if k, ok := s.hashes[meta.SHA1]; ok && k < n-1 {
+			tags.IsRevert = true
+			tags.RevertKind = RevertIdentity
+			tags.RevertedTo = s.order[k]
+			tags.RevertEvidence = append(tags.RevertEvidence, fmt.Sprintf("sha1 of %d", s.order[k]))
+			// Further back than the window it's a restore of an old version, the edits in between aren't listed
+			reverted = s.order[max(k+1, n-revertWindow):n]
+		}
+		s.hashes[meta.SHA1] = n
+	}
+
+	if !tags.IsRevert {
+		if undone := s.undone(changes); len(undone) > 0 {
+			tags.IsRevert = true
+			tags.RevertKind = RevertPartial
+			for _, revID := range slices.Sorted(maps.Keys(undone)) {
+				tags.RevertEvidence = append(tags.RevertEvidence, fmt.Sprintf("content: undoes %d bytes of %d", undone[revID], revID))
+				if !slices.Contains(reverted, revID) {
+					reverted = append(reverted, revID)
+				}
+			}
+			slices.SortFunc(reverted, func(a, b int) int { return s.pos[a] - s.pos[b] })
+		}
+	}
+
+	if claimed && !tags.IsRevert {
+		tags.IsRevert = true
+		tags.RevertKind = RevertClaimed
+		if meta.SHA1 == "" && changes == nil {
+			tags.RevertKind = RevertUnverified
+		}
+	}
+	if tags.IsRevert && tags.RevertedTo == 0 && restored != 0 {
+		tags.RevertedTo = restored
+	}
+	if !tags.IsRevert {
+		return
+	}
+
+	tags.RevertedRevs = slices.Clone(reverted)
+	for _, revID := range reverted {
+		for _, w := range s.window {
+			if w.Process.Meta.RevID == revID && w.Tags.RevertedBy == 0 {
+				w.Tags.IsReverted = true
+				w.Tags.RevertedBy = meta.RevID
+			}
+		}
+	}
+}
+
+// Reads the revision's lines and diffs them with its parent's, nil if its wikitext can't be read
+func (s *revertDetector) changes(meta *RevisionMeta) *revisionLines {
+	text, ok := s.wikitext(meta)
+	if !ok {
+		return nil
+	}
+	cur := &revisionLines{lines: countLines(text)}
+	s.lines[meta.RevID] = cur
+
+	parent, ok := s.lines[meta.ParentID]
+	switch {
+	case meta.ParentID == 0:
+		parent = &revisionLines{}
+	case !ok:
+		return nil
+	}
+	cur.added = subtractLines(cur.lines, parent.lines)
+	cur.removed = subtractLines(parent.lines, cur.lines)
+	return cur
+}
+
+// The revisions in the window whose lines the changes undo, with how many bytes of them:
+// lines put back that the revision removed, lines removed that it added. A line it added
+// and that's now copy-edited into a similar one isn't undone, only one that's gone or
+// replaced by a line it removed.
+func (s *revertDetector) undone(changes *revisionLines) map[int]int {
+	if changes == nil || changes.added == nil {
+		return nil
+	}
+	changed := lineBytes(changes.added) + lineBytes(changes.removed)
+	if changed == 0 {
+		return nil
+	}
+
+	undone := make(map[int]int)
+	total := 0
+	for _, w := range s.window {
+		prev := s.lines[w.Process.Meta.RevID]
+		if prev == nil || prev.added == nil {
+			continue
+		}
+		putBack := intersectLines(changes.added, prev.removed)
+		takenOut := takenOutLines(intersectLines(changes.removed, prev.added), subtractLines(changes.added, putBack))
+		overlap := lineBytes(putBack) + lineBytes(takenOut)
+		if overlap >= partialRevertMinBytes {
+			undone[w.Process.Meta.RevID] = overlap
+			total += overlap
+		}
+	}
+	if float64(total) < partialRevertMinShare*float64(changed) {
+		return nil
+	}
+	return undone
+}
+
+// The removed lines that no added line is an edit of, see sentenceMinSimilarity.
+// Past maxSimilarityPairs comparisons none are, a big rewrite isn't taken as a revert.
+func takenOutLines(removed, added map[string]int) map[string]int {
+	if len(removed)*len(added) > maxSimilarityPairs {
+		return nil
+	}
+	out := make(map[string]int)
+	for line, n := range removed {
+		edited := false
+		for other := range added {
+			if WordSimilarity(line, other) >= sentenceMinSimilarity {
+				edited = true
+				break
+			}
+		}
+		if !edited {
+			out[line] = n
+		}
+	}
+	return out
+}
+
+// The non blank lines of the wikitext, trimmed, and how many times each is there
+func countLines(text string) map[string]int {
+	counts := make(map[string]int)
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			counts[line]++
+		}
+	}
+	return counts
+}
+
+// The lines of a there more times than in b
+func subtractLines(a, b map[string]int) map[string]int {
+	out := make(map[string]int)
+	for line, n := range a {
+		if n > b[line] {
+			out[line] = n - b[line]
+		}
+	}
+	return out
+}
+
+func intersectLines(a, b map[string]int) map[string]int {
+	out := make(map[string]int)
+	for line, n := range a {
+		if m := min(n, b[line]); m > 0 {
+			out[line] = m
+		}
+	}
+	return out
+}
+
+func lineBytes(lines map[string]int) int {
+	total := 0
+	for line, n := range lines {
+		total += len(line) * n
+	}
+	return total
+}
+
+// Whether the comment says it's a revert, and what it reverted or restored if it says so
+func (s *revertDetector) fromComment(rc *RevisionAnalysis) (claimed bool, reverted []int, restored int) {
+	comment := rc.Process.Meta.Comment
+	tags := rc.Tags
+
+	if m := reUndo.FindStringSubmatch(comment); m != nil {
+		tags.RevertEvidence = append(tags.RevertEvidence, "comment: undid revision "+m[1])
+		if id, err := strconv.Atoi(m[1]); err == nil {
+			reverted = append(reverted, id)
+		}
+		return true, reverted, 0
+	}
+
+	if m := reRestored.FindStringSubmatch(comment); m != nil {
+		tags.RevertEvidence = append(tags.RevertEvidence, "comment: restored revision "+m[1])
+		restored, _ = strconv.Atoi(m[1])
+		if k, ok := s.pos[restored]; ok {
+			reverted = s.order[max(k+1, len(s.order)-1-revertWindow) : len(s.order)-1]
+		}
+		return true, reverted, restored
+	}
+
+	if m := reRollback.FindStringSubmatch(comment); m != nil {
+		user := m[1]
+		tags.RevertEvidence = append(tags.RevertEvidence, "comment: reverted edits by "+user)
+		// A rollback reverts the last edits in a row of the user
+		for i := len(s.window) - 1; i >= 0 && s.window[i].Process.Meta.User == user; i-- {
+			reverted = append([]int{s.window[i].Process.Meta.RevID}, reverted...)
+		}
+		return true, reverted, 0
+	}
+
+	if reRevert.MatchString(comment) {
+		tags.RevertEvidence = append(tags.RevertEvidence, "comment: revert")
+		return true, nil, 0
+	}
+
+	return false, nil, 0
+}
@@ -0,0 +1,185 @@
+package preprocessor
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// A revision of a made up history, its wikitext a line per element of lines
+type testRev struct {
+	lines   []string
+	sha1    string
+	comment string
+	tags    []string
+}
+
+// Runs the revisions, ids 1, 2, ... each the parent of the next, through a detector
+func detectReverts(revs []testRev) []*RevisionAnalysis {
+	texts := make(map[int]string)
+	detector := newRevertDetector(func(meta *RevisionMeta) (string, bool) {
+		text, ok := texts[meta.RevID]
+		return text, ok
+	})
+
+	var out []*RevisionAnalysis
+	for i, rev := range revs {
+		meta := &RevisionMeta{RevID: i + 1, ParentID: i, SHA1: rev.sha1, Comment: rev.comment, Tags: rev.tags}
+		if rev.lines != nil {
+			texts[meta.RevID] = strings.Join(rev.lines, "\n")
+		}
+		rc := &RevisionAnalysis{Process: &ProcessCtx{Meta: meta}, Tags: new(RevisionTags)}
+		detector.push(rc)
+		out = append(out, rc)
+	}
+	detector.flush()
+	return out
+}
+
+const (
+	lead   = "Machine learning is a field of study in artificial intelligence."
+	origin = "The term was coined in 1959 by Arthur Samuel of IBM."
+	vandal = "MACHINE LEARNING IS A HOAX MADE UP BY ROBOTS!!!"
+	uses   = "It is used in email filtering and computer vision."
+)
+
+func TestRevertDetector(t *testing.T) {
+	tests := []struct {
+		name string
+		revs []testRev
+		// Of the last revision
+		kind     string
+		reverted []int
+	}{
+		{
+			name: "identity by sha1",
+			revs: []testRev{
+				{lines: []string{lead}, sha1: "a"},
+				{lines: []string{lead, vandal}, sha1: "b"},
+				{lines: []string{lead}, sha1: "a"},
+			},
+			kind:     RevertIdentity,
+			reverted: []int{2},
+		},
+		{
+			// The vandalism is removed but a later edit is kept, no hash matches
+			name: "partial, removes an insertion",
+			revs: []testRev{
+				{lines: []string{lead}, sha1: "a"},
+				{lines: []string{lead, vandal}, sha1: "b"},
+				{lines: []string{lead, vandal, uses}, sha1: "c"},
+				{lines: []string{lead, uses}, sha1: "d"},
+			},
+			kind:     RevertPartial,
+			reverted: []int{2},
+		},
+		{
+			name: "partial, puts back a removal",
+			revs: []testRev{
+				{lines: []string{lead, origin}, sha1: "a"},
+				{lines: []string{lead}, sha1: "b"},
+				{lines: []string{lead, uses}, sha1: "c"},
+				{lines: []string{lead, origin, uses}, sha1: "d"},
+			},
+			kind:     RevertPartial,
+			reverted: []int{2},
+		},
+		{
+			name: "partial, a replaced line changed back",
+			revs: []testRev{
+				{lines: []string{lead, origin}, sha1: "a"},
+				{lines: []string{lead, vandal}, sha1: "b"},
+				{lines: []string{uses, lead, vandal}, sha1: "c"},
+				{lines: []string{uses, lead, origin}, sha1: "d"},
+			},
+			kind:     RevertPartial,
+			reverted: []int{2},
+		},
+		{
+			// The line the last revision added, with a typo fixed, is an edit of it
+			name: "copy-edit of the previous revision's line",
+			revs: []testRev{
+				{lines: []string{lead}, sha1: "a"},
+				{lines: []string{lead, "It is used in emial filtering and computer vision."}, sha1: "b"},
+				{lines: []string{lead, uses}, sha1: "c"},
+			},
+		},
+		{
+			// Under partialRevertMinBytes
+			name: "short line put back",
+			revs: []testRev{
+				{lines: []string{lead, "See also"}, sha1: "a"},
+				{lines: []string{lead}, sha1: "b"},
+				{lines: []string{lead, "See also", uses}, sha1: "c"},
+			},
+		},
+		{
+			// The line put back is a small part of the edit
+			name: "rewrite putting back a line",
+			revs: []testRev{
+				{lines: []string{lead, origin}, sha1: "a"},
+				{lines: []string{lead}, sha1: "b"},
+				{lines: []string{lead, origin, uses, vandal, "Deep learning is a subset of machine learning that uses neural networks."}, sha1: "c"},
+			},
+		},
+		{
+			name: "claimed, the content doesn't show it",
+			revs: []testRev{
+				{lines: []string{lead}, sha1: "a"},
+				{lines: []string{lead, uses}, sha1: "b"},
+				{lines: []string{lead, uses, origin}, sha1: "c", comment: "rv"},
+			},
+			kind: RevertClaimed,
+		},
+		{
+			name: "claimed and partial",
+			revs: []testRev{
+				{lines: []string{lead}, sha1: "a"},
+				{lines: []string{lead, vandal}, sha1: "b"},
+				{lines: []string{lead, vandal, uses}, sha1: "c"},
+				{lines: []string{lead, uses}, sha1: "d", comment: "Undid revision 2 by [[Special:Contributions/X|X]]", tags: []string{tagUndo}},
+			},
+			kind:     RevertPartial,
+			reverted: []int{2},
+		},
+		{
+			name: "unverified, neither hashes nor content",
+			revs: []testRev{
+				{},
+				{},
+				{comment: "Reverted edits by [[Special:Contributions/X|X]]"},
+			},
+			kind: RevertUnverified,
+		},
+		{
+			name: "null edit",
+			revs: []testRev{
+				{lines: []string{lead}, sha1: "a"},
+				{lines: []string{lead}, sha1: "a"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			revs := detectReverts(test.revs)
+			last := revs[len(revs)-1].Tags
+			if last.IsRevert != (test.kind != "") || last.RevertKind != test.kind {
+				t.Fatalf("revert %t of kind %q, want %q, evidence %q", last.IsRevert, last.RevertKind, test.kind, last.RevertEvidence)
+			}
+			if !slices.Equal(last.RevertedRevs, test.reverted) {
+				t.Errorf("reverted %v, want %v", last.RevertedRevs, test.reverted)
+			}
+			for _, rc := range revs[:len(revs)-1] {
+				if rc.Tags.RevertedBy == len(revs) && !slices.Contains(test.reverted, rc.Process.Meta.RevID) {
+					t.Errorf("%d: reverted by the last revision", rc.Process.Meta.RevID)
+				}
+			}
+			for _, revID := range test.reverted {
+				if tags := revs[revID-1].Tags; !tags.IsReverted || tags.RevertedBy != len(revs) {
+					t.Errorf("%d: reverted %t by %d", revID, tags.IsReverted, tags.RevertedBy)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package preprocessor
+
+import (
+	"evolve/wikipedia/history"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Whether the user's rights log is worth asking for. Temporary accounts and IPs get no groups.
+func needsRights(user *UserData) bool {
+	return user.Kind == EditorRegistered && !user.Missing && user.Name != ""
+}
+
+// Fills the rights of the user from its rights log, oldest first
+func (s *Preprocessor) fetchRights(user *UserData) error {
+	query := &history.LogEventsQuery{
+		Type:  "rights",
+		Title: "User:" + user.Name,
+		Props: []string{"timestamp", "details", "type"},
+		Limit: "max",
+		Dir:   "newer",
+	}
+
+	rights := []*RightsChange{}
+	err := history.Paginate(s.ctx, s.client, query, nil, func(page *LogEventsBatch, _ history.Continuation) error {
+		if page.Query == nil {
+			return nil
+		}
+		for _, event := range page.Query.LogEvents {
+			if change := rightsChange(event); change != nil {
+				rights = append(rights, change)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("rights log of %s: %w", user.Name, err)
+	}
+
+	user.Rights = rights
+	user.RightsFetched = true
+	return nil
+}
+
+// nil for a hidden entry, the groups it changed aren't known
+func rightsChange(event *LogEvent) *RightsChange {
+	if event.Params == nil || (event.Params.OldGroups == nil && event.Params.NewGroups == nil) {
+		return nil
+	}
+	change := &RightsChange{
+		TimeStamp: event.TimeStamp,
+		OldGroups: nonNil(event.Params.OldGroups),
+		NewGroups: nonNil(event.Params.NewGroups),
+	}
+	for _, meta := range event.Params.NewMetadata {
+		expiry, err := time.Parse(time.RFC3339, meta.Expiry)
+		// "infinity"
+		if err != nil {
+			continue
+		}
+		if change.Expiries == nil {
+			change.Expiries = make(map[string]time.Time)
+		}
+		change.Expiries[meta.Group] = expiry
+	}
+	return change
+}
+
+// The groups the user held at t, from its current groups and its rights log.
+// Groups the log never mentions, the implicit ones like user and autoconfirmed,
+// are taken as held all along. Without a log the current groups are all there is,
+// current is set when the log wasn't fetched and they may not be the ones held at t.
+func groupsAt(user *UserData, t time.Time) (groups []string, current bool) {
+	if len(user.Rights) == 0 {
+		return user.Groups, needsRights(user) && !user.RightsFetched
+	}
+
+	logged := make(map[string]bool)
+	for _, change := range user.Rights {
+		for _, group := range change.OldGroups {
+			logged[group] = true
+		}
+		for _, group := range change.NewGroups {
+			logged[group] = true
+		}
+	}
+
+	// Before the first change, what it changed from
+	held := user.Rights[0].OldGroups
+	var expiries map[string]time.Time
+	for _, change := range user.Rights {
+		if change.TimeStamp.After(t) {
+			break
+		}
+		held, expiries = change.NewGroups, change.Expiries
+	}
+
+	groups = []string{}
+	for _, group := range user.Groups {
+		if !logged[group] {
+			groups = append(groups, group)
+		}
+	}
+	for _, group := range held {
+		if expiry, ok := expiries[group]; ok && !expiry.After(t) {
+			continue
+		}
+		if !slices.Contains(groups, group) {
+			groups = append(groups, group)
+		}
+	}
+	return groups, false
+}
+
+func nonNil(strs []string) []string {
+	if strs == nil {
+		return []string{}
+	}
+	return strs
+}
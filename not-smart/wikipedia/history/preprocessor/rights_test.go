@@ -0,0 +1,112 @@
+package preprocessor
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Rollbacker in 2010, sysop in 2012, rollbacker removed in 2014,
+// flood for a month in 2015. user and autoconfirmed never were logged.
+func loggedAdmin() *UserData {
+	return &UserData{
+		UserID: 1,
+		Name:   "Admin",
+		Kind:   EditorRegistered,
+		Groups: []string{"user", "autoconfirmed", "sysop"},
+		Rights: []*RightsChange{
+			{TimeStamp: date("2010-01-01"), OldGroups: []string{}, NewGroups: []string{"rollbacker"}},
+			{TimeStamp: date("2012-01-01"), OldGroups: []string{"rollbacker"}, NewGroups: []string{"rollbacker", "sysop"}},
+			{TimeStamp: date("2014-01-01"), OldGroups: []string{"rollbacker", "sysop"}, NewGroups: []string{"sysop"}},
+			{
+				TimeStamp: date("2015-01-01"),
+				OldGroups: []string{"sysop"},
+				NewGroups: []string{"sysop", "flood"},
+				Expiries:  map[string]time.Time{"flood": date("2015-02-01")},
+			},
+		},
+		RightsFetched: true,
+	}
+}
+
+func TestGroupsAt(t *testing.T) {
+	unfetched := loggedAdmin()
+	unfetched.Rights, unfetched.RightsFetched = nil, false
+	emptyLog := loggedAdmin()
+	emptyLog.Rights = []*RightsChange{}
+	ip := &UserData{Name: "192.0.2.1", Kind: EditorIP, Groups: []string{"*"}}
+
+	cases := []struct {
+		name    string
+		user    *UserData
+		at      string
+		want    []string
+		current bool
+	}{
+		{"before the first change", loggedAdmin(), "2009-06-01", []string{"user", "autoconfirmed"}, false},
+		{"granted", loggedAdmin(), "2011-01-01", []string{"user", "autoconfirmed", "rollbacker"}, false},
+		{"on the day of a change", loggedAdmin(), "2012-01-01", []string{"user", "autoconfirmed", "rollbacker", "sysop"}, false},
+		{"removed", loggedAdmin(), "2014-06-01", []string{"user", "autoconfirmed", "sysop"}, false},
+		{"before the expiry", loggedAdmin(), "2015-01-15", []string{"user", "autoconfirmed", "sysop", "flood"}, false},
+		{"on the expiry", loggedAdmin(), "2015-02-01", []string{"user", "autoconfirmed", "sysop"}, false},
+		{"after the expiry", loggedAdmin(), "2020-01-01", []string{"user", "autoconfirmed", "sysop"}, false},
+		{"empty log", emptyLog, "2009-06-01", []string{"user", "autoconfirmed", "sysop"}, false},
+		{"log not fetched", unfetched, "2009-06-01", []string{"user", "autoconfirmed", "sysop"}, true},
+		{"ip", ip, "2009-06-01", []string{"*"}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			groups, current := groupsAt(c.user, date(c.at))
+			if !slices.Equal(groups, c.want) {
+				t.Errorf("groups %v, want %v", groups, c.want)
+			}
+			if current != c.current {
+				t.Errorf("current %t, want %t", current, c.current)
+			}
+		})
+	}
+}
+
+func TestAnalyzeUserMarksCurrentGroups(t *testing.T) {
+	for _, fetched := range []bool{true, false} {
+		user := loggedAdmin()
+		if !fetched {
+			user.Rights, user.RightsFetched = nil, false
+		}
+		ra := &RevisionAnalysis{
+			Process:    &ProcessCtx{Meta: &RevisionMeta{TimeStamp: date("2013-01-01")}, User: user},
+			Tags:       &RevisionTags{},
+			Confidence: &RevisionConfidence{},
+			Trust:      &RevisionTrust{},
+		}
+		if err := (&UserAnalyzer{rules: DefaultRules()}).analyzeUser(ra); err != nil {
+			t.Fatal(err)
+		}
+
+		if ra.Trust.GroupsCurrent == fetched {
+			t.Errorf("fetched %t: groupsCurrent %t", fetched, ra.Trust.GroupsCurrent)
+		}
+		groupFactors := 0
+		for _, factor := range ra.Trust.Factors {
+			if !strings.HasPrefix(factor.Factor, "group:") {
+				continue
+			}
+			groupFactors++
+			if marked := strings.HasSuffix(factor.Factor, groupCurrentSuffix); marked == fetched {
+				t.Errorf("fetched %t: factor %s", fetched, factor.Factor)
+			}
+		}
+		if groupFactors == 0 {
+			t.Errorf("fetched %t: no group factors", fetched)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package preprocessor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+)
+
+// Confidence dimensions a group can weigh on, see RevisionConfidence
+const (
+	DimAutomation  = "automation"
+	DimMaintenance = "maintenance"
+	DimStructural  = "structural"
+	DimHuman       = "human"
+)
+
+var dimensions = []string{DimAutomation, DimMaintenance, DimStructural, DimHuman}
+
+// How each user group, the user's tenure and edit counts, and the signs of automation weigh
+// on the confidences of its edits. DefaultRules, or those with the sections of a JSON file
+// of the same shape over them, see LoadRules. Weights are 0–100 and reinforce the dimension,
+// several factors push it closer to 100 without passing it. The signs of automation of an
+// edit count once, by the strongest of them, see automationDetector.
+type Rules struct {
+	// group → dimension → weight. A rules file adds to the default groups, null drops one.
+	Groups map[GroupTag]map[string]int `json:"groups"`
+	// Groups that flag the edit as a bot's
+	Bots []GroupTag `json:"bots"`
+	// Groups that say nothing, no debug line for them
+	Ignore []GroupTag `json:"ignore"`
+
+	// Account age at the time of the edit, Min in days
+	Tenure []*TrustBucket `json:"tenure"`
+	// Edits of the account on the whole wiki
+	EditCount []*TrustBucket `json:"editCount"`
+	// Edits of the user on the article before this one
+	ArticleEdits []*TrustBucket `json:"articleEdits"`
+
+	// Automated edits of accounts with or without a bot flag, see automationDetector
+	Tools []*ToolSignature `json:"tools"`
+	// An editor's edits coming in bursts or at regular intervals
+	Cadence map[string]int `json:"cadence"`
+	// An editor's edits repeating the same summary and size change
+	Repetitive map[string]int `json:"repetitive"`
+}
+
+// Applies from Min up to the next bucket's Min, buckets go up by Min
+type TrustBucket struct {
+	Min     int            `json:"min"`
+	Weights map[string]int `json:"weights"`
+}
+
+// A tool or bot known by its edit summaries or the change tags it leaves, either is enough
+type ToolSignature struct {
+	Name string `json:"name"`
+	// Regexp matched against the edit summary, RE2 syntax
+	Comment string         `json:"comment,omitempty"`
+	Tags    []string       `json:"tags,omitempty"`
+	Weights map[string]int `json:"weights"`
+}
+
+func DefaultRules() *Rules {
+	return &Rules{
+		Groups: map[GroupTag]map[string]int{
+			// Automation
+			TagBot:            {DimAutomation: 100},
+			TagGlobalBot:      {DimAutomation: 80},
+			TagFlood:          {DimAutomation: 60},
+			TagTemplateEditor: {DimAutomation: 60},
+
+			// Human Content
+			TagUser:              {DimHuman: 85},
+			TagExtendedConfirmed: {DimHuman: 90},
+			TagAutoConfirmed:     {DimHuman: 85},
+			TagConfirmed:         {DimHuman: 85},
+			TagReviewer:          {DimHuman: 80},
+			TagAutoReviewer:      {DimHuman: 80},
+			TagEditor:            {DimHuman: 70},
+			TagResearcher:        {DimHuman: 60},
+			TagAbuseFilter:       {DimHuman: 60},
+			TagTemp:              {DimHuman: 60},
+			TagTempAccViewer:     {DimHuman: 75},
+			TagIPBlockExempty:    {DimHuman: 65},
+
+			// Structural
+			TagSysOp:       {DimStructural: 80},
+			TagGlobalSysOp: {DimStructural: 90},
+
+			// Maintenance
+			TagBureaucrat:    {DimMaintenance: 80},
+			TagCheckUser:     {DimMaintenance: 80},
+			TagRollBacker:    {DimMaintenance: 80},
+			TagPatroller:     {DimMaintenance: 70},
+			TagExtendedMover: {DimMaintenance: 65},
+			TagFileMover:     {DimMaintenance: 50},
+		},
+		Bots:   []GroupTag{TagBot},
+		Ignore: []GroupTag{TagAsterisk},
+
+		// Autoconfirmed after 4 days, extended confirmed after 30 days and 500 edits
+		Tenure: []*TrustBucket{
+			{Min: 0, Weights: map[string]int{}},
+			{Min: 4, Weights: map[string]int{DimHuman: 20}},
+			{Min: 30, Weights: map[string]int{DimHuman: 35}},
+			{Min: 365, Weights: map[string]int{DimHuman: 50}},
+			{Min: 5 * 365, Weights: map[string]int{DimHuman: 65}},
+		},
+		EditCount: []*TrustBucket{
+			{Min: 0, Weights: map[string]int{}},
+			{Min: 10, Weights: map[string]int{DimHuman: 15}},
+			{Min: 500, Weights: map[string]int{DimHuman: 35}},
+			{Min: 10000, Weights: map[string]int{DimHuman: 50, DimMaintenance: 20}},
+			// Hardly anyone gets there by hand
+			{Min: 200000, Weights: map[string]int{DimAutomation: 30, DimMaintenance: 40}},
+		},
+		ArticleEdits: []*TrustBucket{
+			{Min: 0, Weights: map[string]int{}},
+			{Min: 1, Weights: map[string]int{DimHuman: 10}},
+			{Min: 5, Weights: map[string]int{DimHuman: 25}},
+			{Min: 25, Weights: map[string]int{DimHuman: 40}},
+		},
+
+		Tools: []*ToolSignature{
+			{
+				// ... using [[Project:AWB|AWB]]
+				Name:    "AWB",
+				Comment: `(?i)\[\[(?:WP|Wikipedia|Project):(?:AWB|AutoWikiBrowser)\b|\busing AWB\b`,
+				Tags:    []string{"AWB"},
+				Weights: map[string]int{DimAutomation: 70, DimMaintenance: 40},
+			},
+			{
+				// Rescuing 2 sources and tagging 0 as dead.) #IABot (v2.0.9.5
+				Name:    "InternetArchiveBot",
+				Comment: `(?i)#IABot\b|\brescuing \d+ sources? and tagging \d+ as dead`,
+				Weights: map[string]int{DimAutomation: 95, DimMaintenance: 50},
+			},
+			{
+				// Add: doi. | Use this bot. Report bugs. | #UCB_CommandLine
+				Name:    "Citation bot",
+				Comment: `(?i)\bcitation bot\b|#UCB_\w+|\|\s*use this (?:bot|tool)\b`,
+				Weights: map[string]int{DimAutomation: 90, DimMaintenance: 40},
+			},
+			{
+				// Filled in 3 bare reference(s) with reFill 2
+				Name:    "reFill",
+				Comment: `(?i)\bwith reFill\b|\[\[:?(?:en:)?(?:WP|Wikipedia):REFILL\b`,
+				Weights: map[string]int{DimAutomation: 60, DimMaintenance: 40},
+			},
+			{
+				// Unflagged bots and bot trials, "Bot: removing ..."
+				Name:    "bot summary",
+				Comment: `(?i)^\s*(?:ro)?bot\s*:`,
+				Weights: map[string]int{DimAutomation: 60},
+			},
+		},
+		Cadence:    map[string]int{DimAutomation: 40},
+		Repetitive: map[string]int{DimAutomation: 35, DimMaintenance: 20},
+	}
+}
+
+// Reads a rules file over DefaultRules and validates the result. Each section of the file
+// replaces the default one, the ones it leaves out keep their defaults. Groups are merged
+// group by group, and the groups it ignores lose their default weights.
+func LoadRules(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	file := new(Rules)
+	if err = dec.Decode(file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	// Which sections are there, null ones included
+	var sections map[string]json.RawMessage
+	if err = json.Unmarshal(data, &sections); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	rules := DefaultRules()
+	rules.merge(file, sections)
+	if err = rules.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return rules, nil
+}
+
+func (s *Rules) merge(file *Rules, sections map[string]json.RawMessage) {
+	has := func(section string) bool {
+		_, ok := sections[section]
+		return ok
+	}
+
+	for group, weights := range file.Groups {
+		if weights == nil {
+			delete(s.Groups, group)
+			continue
+		}
+		s.Groups[group] = weights
+	}
+	if has("bots") {
+		s.Bots = file.Bots
+	}
+	if has("ignore") {
+		s.Ignore = file.Ignore
+		for _, group := range file.Ignore {
+			if _, weighted := file.Groups[group]; !weighted {
+				delete(s.Groups, group)
+			}
+		}
+	}
+	if has("tenure") {
+		s.Tenure = file.Tenure
+	}
+	if has("editCount") {
+		s.EditCount = file.EditCount
+	}
+	if has("articleEdits") {
+		s.ArticleEdits = file.ArticleEdits
+	}
+	if has("tools") {
+		s.Tools = file.Tools
+	}
+	if has("cadence") {
+		s.Cadence = file.Cadence
+	}
+	if has("repetitive") {
+		s.Repetitive = file.Repetitive
+	}
+}
+
+// Every section has to be there, an empty one turns it off
+func (s *Rules) Validate() error {
+	missing := []struct {
+		name string
+		nil  bool
+	}{
+		{"groups", s.Groups == nil}, {"bots", s.Bots == nil}, {"ignore", s.Ignore == nil},
+		{"tenure", s.Tenure == nil}, {"editCount", s.EditCount == nil}, {"articleEdits", s.ArticleEdits == nil},
+		{"tools", s.Tools == nil}, {"cadence", s.Cadence == nil}, {"repetitive", s.Repetitive == nil},
+	}
+	for _, section := range missing {
+		if section.nil {
+			return fmt.Errorf("no %s section, leave it out for the default or make it empty to turn it off", section.name)
+		}
+	}
+	if len(s.Groups) == 0 {
+		return fmt.Errorf("no groups")
+	}
+	for _, group := range s.sortedGroups() {
+		if group == "" {
+			return fmt.Errorf("empty group name")
+		}
+		if slices.Contains(s.Ignore, group) {
+			return fmt.Errorf("group %q is both weighted and ignored", group)
+		}
+		if err := validateWeights(s.Groups[group]); err != nil {
+			return fmt.Errorf("group %q: %v", group, err)
+		}
+	}
+	for _, group := range s.Bots {
+		if _, ok := s.Groups[group]; !ok {
+			return fmt.Errorf("bot group %q has no weights", group)
+		}
+	}
+	bucketLists := []struct {
+		name    string
+		buckets []*TrustBucket
+	}{{"tenure", s.Tenure}, {"editCount", s.EditCount}, {"articleEdits", s.ArticleEdits}}
+	for _, list := range bucketLists {
+		name, buckets := list.name, list.buckets
+		for i, bucket := range buckets {
+			if bucket == nil {
+				return fmt.Errorf("%s: bucket %d is null", name, i)
+			}
+			if bucket.Min < 0 || (i > 0 && bucket.Min <= buckets[i-1].Min) {
+				return fmt.Errorf("%s: bucket mins can't be negative and have to go up, %d after %d", name, bucket.Min, buckets[max(i-1, 0)].Min)
+			}
+			if err := validateWeights(bucket.Weights); err != nil {
+				return fmt.Errorf("%s: bucket %d: %v", name, bucket.Min, err)
+			}
+		}
+	}
+	for i, tool := range s.Tools {
+		if tool == nil || tool.Name == "" {
+			return fmt.Errorf("tools: tool %d has no name", i)
+		}
+		if tool.Comment == "" && len(tool.Tags) == 0 {
+			return fmt.Errorf("tools: %s has neither a comment nor tags", tool.Name)
+		}
+		if _, err := regexp.Compile(tool.Comment); err != nil {
+			return fmt.Errorf("tools: %s: %v", tool.Name, err)
+		}
+		if err := validateWeights(tool.Weights); err != nil {
+			return fmt.Errorf("tools: %s: %v", tool.Name, err)
+		}
+	}
+	if err := validateWeights(s.Cadence); err != nil {
+		return fmt.Errorf("cadence: %v", err)
+	}
+	if err := validateWeights(s.Repetitive); err != nil {
+		return fmt.Errorf("repetitive: %v", err)
+	}
+	return nil
+}
+
+func validateWeights(weights map[string]int) error {
+	for dim, weight := range weights {
+		if !slices.Contains(dimensions, dim) {
+			return fmt.Errorf("unknown dimension %q, expected one of %v", dim, dimensions)
+		}
+		if weight < 0 || weight > 100 {
+			return fmt.Errorf("%s weight %d isn't within 0–100", dim, weight)
+		}
+	}
+	return nil
+}
+
+// Indented JSON, the format LoadRules reads
+func (s *Rules) JSON() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// Marks the factors of groups that are the current ones, not the ones held at the edit
+const groupCurrentSuffix = ":current"
+
+// Weighs the group on the edit, false if the rules don't know it
+func (s *Rules) apply(rc *RevisionAnalysis, group GroupTag, current bool) bool {
+	weights, ok := s.Groups[group]
+	if !ok {
+		return false
+	}
+	factor := "group:" + string(group)
+	if current {
+		factor += groupCurrentSuffix
+	}
+	applyWeights(rc, factor, weights)
+	if slices.Contains(s.Bots, group) {
+		rc.Tags.IsBot = true
+	}
+	return true
+}
+
+// Weighs the bucket val falls in, nothing below the first one
+func applyBucket(rc *RevisionAnalysis, factor string, buckets []*TrustBucket, val int) {
+	var found *TrustBucket
+	for _, bucket := range buckets {
+		if val >= bucket.Min {
+			found = bucket
+		}
+	}
+	if found != nil {
+		applyWeights(rc, factor, found.Weights)
+	}
+}
+
+// Reinforces the dimensions and records what each weight did, in the order of dimensions
+func applyWeights(rc *RevisionAnalysis, factor string, weights map[string]int) {
+	for _, dim := range dimensions {
+		weight, ok := weights[dim]
+		if !ok {
+			continue
+		}
+		var conf *int
+		switch dim {
+		case DimAutomation:
+			conf = &rc.Confidence.Automation
+		case DimMaintenance:
+			conf = &rc.Confidence.Maintenance
+		case DimStructural:
+			conf = &rc.Confidence.Structural
+		case DimHuman:
+			conf = &rc.Confidence.Human
+		}
+		before := *conf
+		*conf = reinforce(before, weight)
+		rc.Trust.Factors = append(rc.Trust.Factors, &TrustFactor{
+			Factor:    factor,
+			Dimension: dim,
+			Weight:    weight,
+			Delta:     *conf - before,
+		})
+	}
+}
+
+func (s *Rules) ignored(group GroupTag) bool {
+	return slices.Contains(s.Ignore, group)
+}
+
+// For errors that don't depend on the map order
+func (s *Rules) sortedGroups() []GroupTag {
+	groups := make([]GroupTag, 0, len(s.Groups))
+	for group := range s.Groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i] < groups[j] })
+	return groups
+}
@@ -0,0 +1,100 @@
+package preprocessor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadRulesFile(t *testing.T, content string) (*Rules, error) {
+	t.Helper()
+
+	fPath := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(fPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadRules(fPath)
+}
+
+func TestDefaultRulesValid(t *testing.T) {
+	if err := DefaultRules().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadRulesOverDefaults(t *testing.T) {
+	rules, err := loadRulesFile(t, `{
+		"groups": {"sysop": {"maintenance": 90}, "flood": null, "newgroup": {"human": 10}},
+		"cadence": {}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := DefaultRules()
+
+	if w := rules.Groups[TagSysOp]; len(w) != 1 || w[DimMaintenance] != 90 {
+		t.Errorf("sysop %v, want only maintenance 90", w)
+	}
+	if _, ok := rules.Groups[TagFlood]; ok {
+		t.Error("flood is still there")
+	}
+	if rules.Groups["newgroup"][DimHuman] != 10 {
+		t.Error("newgroup wasn't added")
+	}
+	if rules.Groups[TagBot][DimAutomation] != defaults.Groups[TagBot][DimAutomation] {
+		t.Error("bot lost its default weights")
+	}
+	// Left out, the defaults
+	if len(rules.Tenure) != len(defaults.Tenure) || len(rules.Tools) != len(defaults.Tools) || len(rules.Repetitive) != len(defaults.Repetitive) {
+		t.Error("sections left out aren't the defaults")
+	}
+	// Empty, turned off
+	if rules.Cadence == nil || len(rules.Cadence) != 0 {
+		t.Errorf("cadence %v, want empty", rules.Cadence)
+	}
+}
+
+func TestLoadRulesIgnoreDropsDefaultWeights(t *testing.T) {
+	rules, err := loadRulesFile(t, `{"ignore": ["*", "user"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rules.Groups[TagUser]; ok || !rules.ignored(TagUser) {
+		t.Error("user is still weighted")
+	}
+}
+
+func TestLoadRulesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"unknown section", `{"groupz": {}}`, "unknown field"},
+		{"null section", `{"tenure": null}`, "no tenure section"},
+		{"unknown dimension", `{"groups": {"sysop": {"speed": 10}}}`, "unknown dimension"},
+		{"weight over 100", `{"cadence": {"automation": 101}}`, "cadence"},
+		{"buckets going down", `{"editCount": [{"min": 10, "weights": {}}, {"min": 5, "weights": {}}]}`, "editCount"},
+		{"bad regexp", `{"tools": [{"name": "X", "comment": "(", "weights": {}}]}`, "tools: X"},
+		{"tool without signature", `{"tools": [{"name": "X", "weights": {}}]}`, "neither"},
+		{"bot group dropped", `{"groups": {"bot": null}}`, "bot group"},
+		{"weighted and ignored", `{"groups": {"user": {"human": 10}}, "ignore": ["user"]}`, "both weighted and ignored"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadRulesFile(t, test.content)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error %v, want one with %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestValidateMissingSections(t *testing.T) {
+	rules := DefaultRules()
+	rules.Repetitive = nil
+	if err := rules.Validate(); err == nil || !strings.Contains(err.Error(), "repetitive") {
+		t.Errorf("error %v, want one about repetitive", err)
+	}
+}
@@ -0,0 +1,191 @@
+package preprocessor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Section changes
+const (
+	SectionAdded     = "added"
+	SectionRemoved   = "removed"
+	SectionRenamed   = "renamed"
+	SectionReordered = "reordered"
+	SectionEdited    = "edited"
+)
+
+// A section renamed keeps at least this much of its text, see WordSimilarity
+const renameMinSimilarity = 0.5
+
+type sectionPair struct {
+	old, new *Section
+	// Index in the old and the new revision, depth first
+	oldIdx, newIdx int
+}
+
+// Matches the sections of the parent and the revision, by path of headings first,
+// then by heading for the ones that moved under another parent, then by text for
+// the renamed ones. Either can be nil, the first revision has no parent.
+func diffSections(oldRoot, newRoot *Section) *SectionDiffs {
+	var oldSecs, newSecs []*Section
+	oldPaths, newPaths := map[*Section]string{}, map[*Section]string{}
+	if oldRoot != nil {
+		oldSecs, oldPaths = oldRoot.Flatten(), SectionPaths(oldRoot)
+	}
+	if newRoot != nil {
+		newSecs, newPaths = newRoot.Flatten(), SectionPaths(newRoot)
+	}
+
+	pairs := []*sectionPair{}
+	oldMatched := make([]bool, len(oldSecs))
+	newMatched := make([]bool, len(newSecs))
+	match := func(key func(sec *Section, paths map[*Section]string) string) {
+		// Duplicate keys pair up in order
+		byKey := make(map[string][]int)
+		for i, sec := range oldSecs {
+			if !oldMatched[i] {
+				k := key(sec, oldPaths)
+				byKey[k] = append(byKey[k], i)
+			}
+		}
+		for j, sec := range newSecs {
+			if newMatched[j] {
+				continue
+			}
+			k := key(sec, newPaths)
+			if len(byKey[k]) == 0 {
+				continue
+			}
+			i := byKey[k][0]
+			byKey[k] = byKey[k][1:]
+			oldMatched[i], newMatched[j] = true, true
+			pairs = append(pairs, &sectionPair{old: oldSecs[i], new: sec, oldIdx: i, newIdx: j})
+		}
+	}
+	match(func(sec *Section, paths map[*Section]string) string {
+		return fmt.Sprintf("%d/%s", sec.Level, paths[sec])
+	})
+	match(func(sec *Section, paths map[*Section]string) string {
+		return fmt.Sprintf("%d/%s", sec.Level, sec.Heading)
+	})
+
+	// Renames, best similarity first among sections of the same level
+	for j, sec := range newSecs {
+		if newMatched[j] || strings.TrimSpace(sec.Text) == "" {
+			continue
+		}
+		best, bestSim := -1, renameMinSimilarity
+		for i, old := range oldSecs {
+			if oldMatched[i] || old.Level != sec.Level || strings.TrimSpace(old.Text) == "" {
+				continue
+			}
+			if sim := WordSimilarity(old.Text, sec.Text); sim >= bestSim {
+				best, bestSim = i, sim
+			}
+		}
+		if best >= 0 {
+			oldMatched[best], newMatched[j] = true, true
+			pairs = append(pairs, &sectionPair{old: oldSecs[best], new: sec, oldIdx: best, newIdx: j})
+		}
+	}
+
+	diffs := &SectionDiffs{Changes: []*SectionChange{}}
+	reordered := reorderedPairs(pairs)
+
+	for _, p := range pairs {
+		ins, del, _ := wordCounts(strings.Fields(p.old.Text), strings.Fields(p.new.Text))
+		change := &SectionChange{
+			Path:     newPaths[p.new],
+			Heading:  p.new.Heading,
+			Level:    p.new.Level,
+			Inserted: int(ins),
+			Deleted:  int(del),
+		}
+		if oldPath := oldPaths[p.old]; oldPath != change.Path {
+			change.OldPath = oldPath
+		}
+
+		switch {
+		case p.old.Heading != p.new.Heading:
+			change.Change = SectionRenamed
+			diffs.Renamed++
+		case reordered[p]:
+			change.Change = SectionReordered
+			diffs.Reordered++
+		case ins+del > 0:
+			change.Change = SectionEdited
+		default:
+			// Unchanged, not listed
+			continue
+		}
+		if reordered[p] && change.Change != SectionReordered {
+			diffs.Reordered++
+		}
+		diffs.Changes = append(diffs.Changes, change)
+	}
+
+	for j, sec := range newSecs {
+		if !newMatched[j] {
+			diffs.Added++
+			diffs.Changes = append(diffs.Changes, &SectionChange{
+				Path:     newPaths[sec],
+				Heading:  sec.Heading,
+				Level:    sec.Level,
+				Change:   SectionAdded,
+				Inserted: len(strings.Fields(sec.Text)),
+			})
+		}
+	}
+	for i, sec := range oldSecs {
+		if !oldMatched[i] {
+			diffs.Removed++
+			diffs.Changes = append(diffs.Changes, &SectionChange{
+				Path:    oldPaths[sec],
+				Heading: sec.Heading,
+				Level:   sec.Level,
+				Change:  SectionRemoved,
+				Deleted: len(strings.Fields(sec.Text)),
+			})
+		}
+	}
+
+	return diffs
+}
+
+// The pairs that moved, the ones out of the longest run kept in the same relative order
+func reorderedPairs(pairs []*sectionPair) map[*sectionPair]bool {
+	byNew := make([]*sectionPair, len(pairs))
+	copy(byNew, pairs)
+	sort.Slice(byNew, func(a, b int) bool { return byNew[a].newIdx < byNew[b].newIdx })
+
+	// Longest increasing subsequence of the old indexes, O(n²) is fine for sections
+	n := len(byNew)
+	length := make([]int, n)
+	prev := make([]int, n)
+	end := -1
+	for i := range byNew {
+		length[i], prev[i] = 1, -1
+		for j := 0; j < i; j++ {
+			if byNew[j].oldIdx < byNew[i].oldIdx && length[j]+1 > length[i] {
+				length[i], prev[i] = length[j]+1, j
+			}
+		}
+		if end < 0 || length[i] > length[end] {
+			end = i
+		}
+	}
+
+	inOrder := make(map[*sectionPair]bool, n)
+	for i := end; i >= 0; i = prev[i] {
+		inOrder[byNew[i]] = true
+	}
+
+	reordered := make(map[*sectionPair]bool)
+	for _, p := range pairs {
+		if !inOrder[p] {
+			reordered[p] = true
+		}
+	}
+	return reordered
+}
@@ -0,0 +1,177 @@
+package preprocessor
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+const (
+	secLead   = "ML is a field of study.\n"
+	secHist   = "== History ==\nThe term was coined in 1959 by Arthur Samuel.\n"
+	secEarly  = "=== Early days ===\nPerceptrons were built in 1958.\n"
+	secUses   = "== Uses ==\nIt is used in email filtering and computer vision.\n"
+	secTheory = "== Theory ==\nLearning theory studies the performance of learners.\n"
+)
+
+// The sections of the wikitext as the cleaner makes them, see splitSections
+func sectionTree(t *testing.T, wikitext string) *Section {
+	t.Helper()
+
+	_, root, err := cleanSections(context.Background(), NewNativeBackend(), wikitext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestDiffSections(t *testing.T) {
+	cases := []struct {
+		name     string
+		old, new string
+		// Counts of added, removed, renamed, reordered
+		counts [4]int
+		// <change>:<path>, sorted
+		changes []string
+		// Of the renamed or moved one
+		oldPath string
+	}{
+		{
+			name: "unchanged",
+			old:  secLead + secHist + secUses,
+			new:  secLead + secHist + secUses,
+		},
+		{
+			name:    "edited",
+			old:     secLead + secHist + secUses,
+			new:     secLead + secHist + "== Uses ==\nIt is used in spam filtering and computer vision.\n",
+			changes: []string{"edited:Uses"},
+		},
+		{
+			name:    "added",
+			old:     secLead + secHist + secUses,
+			new:     secLead + secHist + secTheory + secUses,
+			counts:  [4]int{1, 0, 0, 0},
+			changes: []string{"added:Theory"},
+		},
+		{
+			name:    "removed",
+			old:     secLead + secHist + secUses,
+			new:     secLead + secHist,
+			counts:  [4]int{0, 1, 0, 0},
+			changes: []string{"removed:Uses"},
+		},
+		{
+			name:    "renamed",
+			old:     secLead + secHist + secUses,
+			new:     secLead + secHist + "== Applications ==\nIt is used in email filtering and computer vision.\n",
+			counts:  [4]int{0, 0, 1, 0},
+			changes: []string{"renamed:Applications"},
+			oldPath: "Uses",
+		},
+		{
+			// Another heading with a different text is a new section
+			name:    "replaced",
+			old:     secLead + secHist + secUses,
+			new:     secLead + secHist + secTheory,
+			counts:  [4]int{1, 1, 0, 0},
+			changes: []string{"added:Theory", "removed:Uses"},
+		},
+		{
+			name:    "reordered",
+			old:     secLead + secHist + secUses,
+			new:     secLead + secUses + secHist,
+			counts:  [4]int{0, 0, 0, 1},
+			changes: []string{"reordered:History"},
+		},
+		{
+			// Matched by heading, under another parent
+			name:    "moved under another section",
+			old:     secLead + secHist + secEarly + secUses,
+			new:     secLead + secHist + secUses + secEarly,
+			counts:  [4]int{0, 0, 0, 1},
+			changes: []string{"reordered:Uses/Early days"},
+			oldPath: "History/Early days",
+		},
+		{
+			name:    "renamed and another reordered",
+			old:     secLead + secHist + secUses,
+			new:     secLead + "== Applications ==\nIt is used in email filtering and computer vision.\n" + secHist,
+			counts:  [4]int{0, 0, 1, 1},
+			changes: []string{"renamed:Applications", "reordered:History"},
+			oldPath: "Uses",
+		},
+		{
+			name:    "page creation",
+			new:     secLead + secHist,
+			counts:  [4]int{2, 0, 0, 0},
+			changes: []string{"added:", "added:History"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var oldRoot *Section
+			if c.old != "" {
+				oldRoot = sectionTree(t, c.old)
+			}
+			diffs := diffSections(oldRoot, sectionTree(t, c.new))
+
+			counts := [4]int{diffs.Added, diffs.Removed, diffs.Renamed, diffs.Reordered}
+			if counts != c.counts {
+				t.Errorf("added, removed, renamed, reordered %v, want %v", counts, c.counts)
+			}
+			changes := []string{}
+			oldPath := ""
+			for _, change := range diffs.Changes {
+				changes = append(changes, change.Change+":"+change.Path)
+				if change.OldPath != "" {
+					oldPath = change.OldPath
+				}
+			}
+			slices.Sort(changes)
+			if !slices.Equal(changes, c.changes) && len(changes)+len(c.changes) > 0 {
+				t.Errorf("changes %v, want %v", changes, c.changes)
+			}
+			if oldPath != c.oldPath {
+				t.Errorf("old path %q, want %q", oldPath, c.oldPath)
+			}
+		})
+	}
+}
+
+func TestReorderedPairs(t *testing.T) {
+	cases := []struct {
+		// Old index of the section at each new index
+		oldIdxs []int
+		// New indexes of the reordered ones
+		want []int
+	}{
+		{nil, nil},
+		{[]int{0, 1, 2}, nil},
+		{[]int{2, 0, 1}, []int{0}},
+		// Of two runs as long, the first one found stays
+		{[]int{0, 2, 1}, []int{2}},
+		{[]int{3, 2, 1, 0}, []int{1, 2, 3}},
+		{[]int{1, 0, 3, 2}, []int{1, 3}},
+		// Gaps from added and removed sections don't matter
+		{[]int{0, 5, 9}, nil},
+	}
+	for _, c := range cases {
+		pairs := []*sectionPair{}
+		for j, i := range c.oldIdxs {
+			pairs = append(pairs, &sectionPair{oldIdx: i, newIdx: j})
+		}
+		// Not in new order, as diffSections appends the renames last
+		slices.Reverse(pairs)
+
+		reordered := reorderedPairs(pairs)
+		got := []int{}
+		for p := range reordered {
+			got = append(got, p.newIdx)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, c.want) && len(got)+len(c.want) > 0 {
+			t.Errorf("old indexes %v: reordered %v, want %v", c.oldIdxs, got, c.want)
+		}
+	}
+}
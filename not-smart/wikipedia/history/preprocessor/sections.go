@@ -0,0 +1,214 @@
+package preprocessor
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A section of a cleaned revision. The lead is the level 0 root, the rest hang
+// under the nearest heading before them with a lower level.
+type Section struct {
+	Heading string `json:"heading"`
+	Level   int    `json:"level"`
+	// Cleaned text without the heading and without the sub sections
+	Text string `json:"text"`
+	// Byte offsets of the heading and Text in RevisionClean.Content, End excluded
+	Start int `json:"start"`
+	End   int `json:"end"`
+
+	Sections []*Section `json:"sections,omitempty"`
+}
+
+// Where the section and all its sub sections end in Content
+func (s *Section) SpanEnd() int {
+	if len(s.Sections) == 0 {
+		return s.End
+	}
+	return s.Sections[len(s.Sections)-1].SpanEnd()
+}
+
+// Every section depth first, the lead included
+func (s *Section) Flatten() []*Section {
+	out := []*Section{s}
+	for _, sub := range s.Sections {
+		out = append(out, sub.Flatten()...)
+	}
+	return out
+}
+
+// Path of headings from the lead, e.g. "History/Early days", the lead is ""
+func SectionPaths(root *Section) map[*Section]string {
+	paths := make(map[*Section]string)
+	var walk func(sec *Section, prefix string)
+	walk = func(sec *Section, prefix string) {
+		paths[sec] = prefix
+		for _, sub := range sec.Sections {
+			path := sub.Heading
+			if prefix != "" {
+				path = prefix + "/" + sub.Heading
+			}
+			walk(sub, path)
+		}
+	}
+	walk(root, "")
+	return paths
+}
+
+// A heading line and the wikitext up to the next one
+type rawSection struct {
+	heading string
+	level   int
+	body    string
+}
+
+var (
+	reHeadingLine = regexp.MustCompile(`^(={1,6})(.+?)(={1,6})\s*$`)
+	// Blocks where a line of = isn't a heading
+	reVerbatimOpen  = regexp.MustCompile(`(?i)<(pre|nowiki|syntaxhighlight|source|math)\b(?:[^>]*[^/>])?>|<!--`)
+	reVerbatimClose = regexp.MustCompile(`(?i)</(pre|nowiki|syntaxhighlight|source|math)\s*>|-->`)
+)
+
+// Cuts the wikitext at its headings like MediaWiki does, the lead comes first with level 0
+func splitSections(wikitext string) []*rawSection {
+	sections := []*rawSection{{}}
+	var body strings.Builder
+	verbatim := 0
+
+	for _, line := range strings.SplitAfter(wikitext, "\n") {
+		trimmed := strings.TrimRight(line, "\r\n")
+		if verbatim == 0 {
+			if m := reHeadingLine.FindStringSubmatch(trimmed); m != nil {
+				sections[len(sections)-1].body = body.String()
+				body.Reset()
+
+				// == A === is a level 2 "A =", the extra = are text
+				level := min(len(m[1]), len(m[3]))
+				text := strings.Repeat("=", len(m[1])-level) + m[2] + strings.Repeat("=", len(m[3])-level)
+				sections = append(sections, &rawSection{
+					heading: WikitextToPlain(text),
+					level:   level,
+				})
+				continue
+			}
+		}
+		verbatim += len(reVerbatimOpen.FindAllString(line, -1)) - len(reVerbatimClose.FindAllString(line, -1))
+		verbatim = max(verbatim, 0)
+		body.WriteString(line)
+	}
+	sections[len(sections)-1].body = body.String()
+
+	return sections
+}
+
+// Put between the sections so the whole revision is cleaned in one go, a word
+// alone on its line goes through both cleaners as it is
+const sectionBreak = "evolvesectionbreak"
+
+var reSectionBreak = regexp.MustCompile(`(?m)^` + sectionBreak + `(\d+)[ \t]*$`)
+
+// Cleans the sections and lays them out in content, headings on their own
+// line and a blank line between sections
+func cleanSections(ctx context.Context, backend CleanBackend, wikitext string) (string, *Section, error) {
+	raws := splitSections(wikitext)
+	texts, err := cleanBodies(ctx, backend, raws)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var content strings.Builder
+	root := &Section{}
+	stack := []*Section{root}
+
+	for i, raw := range raws {
+		text := texts[i]
+
+		sec := root
+		if i > 0 {
+			sec = &Section{Heading: raw.heading, Level: raw.level}
+			for stack[len(stack)-1].Level >= sec.Level {
+				stack = stack[:len(stack)-1]
+			}
+			parent := stack[len(stack)-1]
+			parent.Sections = append(parent.Sections, sec)
+			stack = append(stack, sec)
+		}
+		sec.Text = text
+
+		if content.Len() > 0 && (sec.Heading != "" || text != "") {
+			content.WriteString("\n\n")
+		}
+		sec.Start = content.Len()
+		if sec.Heading != "" {
+			content.WriteString(sec.Heading)
+			if text != "" {
+				content.WriteString("\n")
+			}
+		}
+		content.WriteString(text)
+		sec.End = content.Len()
+	}
+
+	return content.String(), root, nil
+}
+
+// The cleaned text of every section, all of them in a single call to the backend, one
+// pandoc request per revision. Markup left open in a section, an unclosed comment or
+// ref, can swallow the breaks after it, the sections are then cleaned one by one.
+func cleanBodies(ctx context.Context, backend CleanBackend, raws []*rawSection) ([]string, error) {
+	blank := true
+	var doc strings.Builder
+	for i, raw := range raws {
+		if i > 0 {
+			fmt.Fprintf(&doc, "\n\n%s%d\n\n", sectionBreak, i)
+		}
+		doc.WriteString(raw.body)
+		blank = blank && strings.TrimSpace(raw.body) == ""
+	}
+	if blank {
+		return make([]string, len(raws)), nil
+	}
+	out, err := backend.Clean(ctx, doc.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if texts, ok := splitBodies(out, len(raws)); ok {
+		return texts, nil
+	}
+
+	texts := make([]string, len(raws))
+	for i, raw := range raws {
+		if strings.TrimSpace(raw.body) == "" {
+			continue
+		}
+		text, err := backend.Clean(ctx, raw.body)
+		if err != nil {
+			return nil, err
+		}
+		texts[i] = strings.TrimSpace(text)
+	}
+	return texts, nil
+}
+
+// Cuts the cleaned revision at the section breaks, not ok unless every one of them is there in order
+func splitBodies(out string, sections int) ([]string, bool) {
+	breaks := reSectionBreak.FindAllStringSubmatchIndex(out, -1)
+	if len(breaks) != sections-1 {
+		return nil, false
+	}
+
+	texts := make([]string, 0, sections)
+	start := 0
+	for i, loc := range breaks {
+		if n, err := strconv.Atoi(out[loc[2]:loc[3]]); err != nil || n != i+1 {
+			return nil, false
+		}
+		texts = append(texts, strings.TrimSpace(out[start:loc[0]]))
+		start = loc[1]
+	}
+	texts = append(texts, strings.TrimSpace(out[start:]))
+	return texts, true
+}
@@ -0,0 +1,86 @@
+package preprocessor
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// Counts the calls to the native cleaner
+type countingBackend struct {
+	NativeBackend
+	calls int
+}
+
+func (s *countingBackend) Clean(ctx context.Context, wikitext string) (string, error) {
+	s.calls++
+	return s.NativeBackend.Clean(ctx, wikitext)
+}
+
+func TestCleanSections(t *testing.T) {
+	tests := []struct {
+		name     string
+		wikitext string
+		content  string
+		headings []string
+		calls    int
+	}{
+		{
+			name:     "one call per revision",
+			wikitext: "'''ML''' is a field.<ref>Cite</ref>\n\n== History ==\nIt started.\n\n=== Early days ===\n* In 1959.\n\n== Uses ==\n\n== See also ==\n[[Deep learning]]\n",
+			content:  "ML is a field.\n\nHistory\nIt started.\n\nEarly days\nIn 1959.\n\nUses\n\nSee also\nDeep learning",
+			headings: []string{"", "History", "Early days", "Uses", "See also"},
+			calls:    1,
+		},
+		{
+			name:     "no lead",
+			wikitext: "== Only ==\nText.",
+			content:  "Only\nText.",
+			headings: []string{"", "Only"},
+			calls:    1,
+		},
+		{
+			name:     "nothing to clean",
+			wikitext: "== A ==\n\n== B ==\n",
+			content:  "A\n\nB",
+			headings: []string{"", "A", "B"},
+			calls:    0,
+		},
+		{
+			// The ref runs to the next section's </ref> and swallows the break,
+			// every section is cleaned on its own
+			name:     "unclosed ref",
+			wikitext: "Lead.<ref>Never closed\n== History ==\nIt started.<ref>Cite</ref>",
+			content:  "Lead.Never closed\n\nHistory\nIt started.",
+			headings: []string{"", "History"},
+			calls:    3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := new(countingBackend)
+			content, root, err := cleanSections(context.Background(), backend, test.wikitext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if content != test.content {
+				t.Errorf("content:\n%q\nwant:\n%q", content, test.content)
+			}
+			if backend.calls != test.calls {
+				t.Errorf("%d calls to the backend, want %d", backend.calls, test.calls)
+			}
+
+			var headings []string
+			for _, sec := range root.Flatten() {
+				headings = append(headings, sec.Heading)
+				if got := content[sec.Start:sec.End]; !strings.HasSuffix(got, sec.Text) {
+					t.Errorf("section %q spans %q", sec.Heading, got)
+				}
+			}
+			if strings.Join(headings, "|") != strings.Join(test.headings, "|") {
+				t.Errorf("headings %q, want %q", headings, test.headings)
+			}
+		})
+	}
+}
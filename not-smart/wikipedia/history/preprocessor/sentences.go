@@ -0,0 +1,331 @@
+package preprocessor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Sentence changes
+const (
+	SentenceAdded    = "added"
+	SentenceRemoved  = "removed"
+	SentenceModified = "modified"
+	SentenceMoved    = "moved"
+)
+
+// A sentence modified keeps at least this much of its words, see WordSimilarity
+const sentenceMinSimilarity = 0.5
+
+// Runs of changes bigger than this, removed × added, aren't paired by similarity,
+// a blanked and restored article would cost too much. Moved sentences are still found.
+const maxSimilarityPairs = 10000
+
+// A sentence and the ID it keeps through its edits, "<revid>-<n>" of the
+// revision it first appeared in, n its index there
+type Sentence struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// What's saved in sentences/, read back for the children of the revision
+type RevisionSentences struct {
+	RevID     int         `json:"revid"`
+	ParentID  int         `json:"parentid"`
+	Sentences []*Sentence `json:"sentences"`
+}
+
+// Words ending in a dot that don't end a sentence, lower case without the dot
+var abbreviations = map[string]bool{
+	"e.g": true, "i.e": true, "etc": true, "vs": true, "cf": true, "al": true,
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "st": true,
+	"jr": true, "sr": true, "no": true, "fig": true, "ca": true, "approx": true,
+	"inc": true, "ltd": true, "co": true, "vol": true, "pp": true, "ed": true,
+}
+
+// Splits cleaned text in sentences, every line is at least its own sentence.
+// A sentence ends at . ! or ? followed by a space and something that can start one,
+// unless the dot ends an abbreviation, an initial or a dotted acronym like U.S.
+func SplitSentences(text string) []string {
+	sentences := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		start := 0
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			if c != '.' && c != '!' && c != '?' {
+				continue
+			}
+			// Closing quotes and brackets stay with the sentence
+			end := i + 1
+			for end < len(line) {
+				r, size := utf8.DecodeRuneInString(line[end:])
+				if !strings.ContainsRune(`"')]’”»`, r) {
+					break
+				}
+				end += size
+			}
+			if end < len(line) && line[end] != ' ' {
+				continue
+			}
+			if end < len(line) && !startsSentence(strings.TrimLeft(line[end:], " ")) {
+				continue
+			}
+			if c == '.' && !endsSentence(line[start:i]) {
+				continue
+			}
+			if sentence := strings.TrimSpace(line[start:end]); sentence != "" {
+				sentences = append(sentences, sentence)
+			}
+			start = end
+			i = end - 1
+		}
+		if sentence := strings.TrimSpace(line[start:]); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+	}
+	return sentences
+}
+
+func startsSentence(rest string) bool {
+	r, _ := utf8.DecodeRuneInString(rest)
+	return unicode.IsUpper(r) || unicode.IsDigit(r) || strings.ContainsRune(`"'(['‘“«`, r)
+}
+
+// before is the text of the sentence up to the dot
+func endsSentence(before string) bool {
+	word := before
+	if i := strings.LastIndexAny(before, " ("); i >= 0 {
+		word = before[i+1:]
+	}
+	if abbreviations[strings.ToLower(word)] {
+		return false
+	}
+	// Initials, J. R. R. Tolkien, and acronyms, U.S.
+	if utf8.RuneCountInString(word) == 1 || strings.Contains(word, ".") {
+		r, _ := utf8.DecodeRuneInString(word)
+		return !unicode.IsLetter(r)
+	}
+	return true
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+// Gives the sentences of a revision their IDs, from the parent's sentences when they
+// survived, even modified, new ones otherwise, and what changed.
+// Sentences are first aligned in order, the ones left in a run of changes are paired
+// by similarity, then what's left is matched anywhere in the text, as moved if it's
+// the same and as modified if it's similar.
+func alignSentences(parent []*Sentence, texts []string, revID int) ([]*Sentence, *SentenceDiffs) {
+	sentences := make([]*Sentence, len(texts))
+	diffs := &SentenceDiffs{Changes: []*SentenceChange{}}
+
+	oldTexts := make([]string, len(parent))
+	for i, sen := range parent {
+		oldTexts[i] = sen.Text
+	}
+
+	dmp := diffmatchpatch.New()
+	// One sentence per "line", so every sentence is one token
+	oldChars, newChars, lines := dmp.DiffLinesToChars(joinLines(oldTexts), joinLines(texts))
+	diffs1 := dmp.DiffCharsToLines(dmp.DiffMain(oldChars, newChars, false), lines)
+
+	var removed, added []int
+	oldIdx, newIdx := 0, 0
+	modify := func(i, j int) {
+		sentences[j] = &Sentence{ID: parent[i].ID, Text: texts[j]}
+		diffs.Modified++
+		diffs.Changes = append(diffs.Changes, &SentenceChange{
+			ID:         parent[i].ID,
+			Change:     SentenceModified,
+			Text:       texts[j],
+			OldText:    parent[i].Text,
+			Similarity: int(WordSimilarity(parent[i].Text, texts[j]) * 100),
+		})
+	}
+
+	// A run of deletions and insertions between unchanged sentences
+	var runOld, runNew []int
+	flushRun := func() {
+		pairs := pairBySimilarity(parent, runOld, texts, runNew)
+		for _, p := range pairs {
+			modify(p[0], p[1])
+		}
+		for _, i := range runOld {
+			if !pairedOld(pairs, i) {
+				removed = append(removed, i)
+			}
+		}
+		for _, j := range runNew {
+			if sentences[j] == nil {
+				added = append(added, j)
+			}
+		}
+		runOld, runNew = nil, nil
+	}
+
+	for _, d := range diffs1 {
+		n := strings.Count(d.Text, "\n")
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			flushRun()
+			for range n {
+				sentences[newIdx] = &Sentence{ID: parent[oldIdx].ID, Text: texts[newIdx]}
+				oldIdx++
+				newIdx++
+			}
+			diffs.Unchanged += n
+		case diffmatchpatch.DiffDelete:
+			for range n {
+				runOld = append(runOld, oldIdx)
+				oldIdx++
+			}
+		case diffmatchpatch.DiffInsert:
+			for range n {
+				runNew = append(runNew, newIdx)
+				newIdx++
+			}
+		}
+	}
+	flushRun()
+
+	// Moved, the same sentence removed somewhere and added elsewhere
+	removedByText := make(map[string][]int)
+	for _, i := range removed {
+		removedByText[parent[i].Text] = append(removedByText[parent[i].Text], i)
+	}
+	moved := make(map[int]bool)
+	for _, j := range added {
+		if len(removedByText[texts[j]]) == 0 {
+			continue
+		}
+		i := removedByText[texts[j]][0]
+		removedByText[texts[j]] = removedByText[texts[j]][1:]
+		moved[i] = true
+		sentences[j] = &Sentence{ID: parent[i].ID, Text: texts[j]}
+		diffs.Moved++
+		diffs.Changes = append(diffs.Changes, &SentenceChange{ID: parent[i].ID, Change: SentenceMoved, Text: texts[j]})
+	}
+
+	// Moved and modified
+	var leftOld, leftNew []int
+	for _, i := range removed {
+		if !moved[i] {
+			leftOld = append(leftOld, i)
+		}
+	}
+	for _, j := range added {
+		if sentences[j] == nil {
+			leftNew = append(leftNew, j)
+		}
+	}
+	for _, p := range pairBySimilarity(parent, leftOld, texts, leftNew) {
+		moved[p[0]] = true
+		modify(p[0], p[1])
+	}
+
+	for _, j := range added {
+		if sentences[j] != nil {
+			continue
+		}
+		sentences[j] = &Sentence{ID: fmt.Sprintf("%d-%d", revID, j), Text: texts[j]}
+		diffs.Added++
+		diffs.Changes = append(diffs.Changes, &SentenceChange{ID: sentences[j].ID, Change: SentenceAdded, Text: texts[j]})
+	}
+	for _, i := range removed {
+		if moved[i] {
+			continue
+		}
+		diffs.Removed++
+		diffs.Changes = append(diffs.Changes, &SentenceChange{ID: parent[i].ID, Change: SentenceRemoved, Text: parent[i].Text})
+	}
+
+	return sentences, diffs
+}
+
+// One per line, nothing at all for no lines
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Pairs of (old, new) indexes, the most similar first, each used once
+func pairBySimilarity(parent []*Sentence, oldIdxs []int, texts []string, newIdxs []int) [][2]int {
+	if len(oldIdxs) == 0 || len(newIdxs) == 0 || len(oldIdxs)*len(newIdxs) > maxSimilarityPairs {
+		return nil
+	}
+
+	type candidate struct {
+		i, j int
+		sim  float64
+	}
+	candidates := []candidate{}
+	for _, i := range oldIdxs {
+		for _, j := range newIdxs {
+			if sim := WordSimilarity(parent[i].Text, texts[j]); sim >= sentenceMinSimilarity {
+				candidates = append(candidates, candidate{i, j, sim})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].sim > candidates[b].sim })
+
+	usedOld, usedNew := map[int]bool{}, map[int]bool{}
+	pairs := [][2]int{}
+	for _, c := range candidates {
+		if usedOld[c.i] || usedNew[c.j] {
+			continue
+		}
+		usedOld[c.i], usedNew[c.j] = true, true
+		pairs = append(pairs, [2]int{c.i, c.j})
+	}
+	return pairs
+}
+
+func pairedOld(pairs [][2]int, i int) bool {
+	for _, p := range pairs {
+		if p[0] == i {
+			return true
+		}
+	}
+	return false
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+// The sentences of the parent, nil if it has none saved, e.g. processed before sentences were kept
+func (s *Differ) readSentences(parentID int) ([]*Sentence, error) {
+	matches, err := filepath.Glob(filepath.Join(s.sentencesDir, fmt.Sprintf("*-%d.json", parentID)))
+	if err != nil || len(matches) == 0 {
+		return nil, err
+	}
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		return nil, err
+	}
+	revSentences := new(RevisionSentences)
+	if err = json.Unmarshal(data, revSentences); err != nil {
+		return nil, fmt.Errorf("sentences of %d unmarshall error: %v", parentID, err)
+	}
+	return revSentences.Sentences, nil
+}
+
+func (s *Differ) saveSentences(rc *RevisionAnalysis, sentences []*Sentence) error {
+	fName := fmt.Sprintf("%d-%d.json", rc.Process.Meta.TimeStamp.Unix(), rc.Process.Meta.RevID)
+	data, err := json.Marshal(&RevisionSentences{
+		RevID:     rc.Process.Meta.RevID,
+		ParentID:  rc.Process.Meta.ParentID,
+		Sentences: sentences,
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.sentencesDir, fName), data, 0700)
+}
@@ -0,0 +1,186 @@
+package preprocessor
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestSplitSentences(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"empty", "", []string{}},
+		{"two", "One here. Two here.", []string{"One here.", "Two here."}},
+		{"exclamation and question", "Really? Yes! Fine.", []string{"Really?", "Yes!", "Fine."}},
+		{"lines", "Heading\nText one. Text two.\n\n", []string{"Heading", "Text one.", "Text two."}},
+		{"abbreviation", "Dr. Smith came. He left.", []string{"Dr. Smith came.", "He left."}},
+		{"initials", "J. R. R. Tolkien wrote it. Then more.", []string{"J. R. R. Tolkien wrote it.", "Then more."}},
+		{"acronym", "The U.S. Army fought. It won.", []string{"The U.S. Army fought.", "It won."}},
+		{"decimal", "Version 2.0 is out. It works.", []string{"Version 2.0 is out.", "It works."}},
+		{"lower case after", "It costs 5. dollars more.", []string{"It costs 5. dollars more."}},
+		{"digit after", "It ended. 1990 was next.", []string{"It ended.", "1990 was next."}},
+		{"quote", `He said "no." Then left.`, []string{`He said "no."`, "Then left."}},
+		{"curly quote", "He said “no.” Then left.", []string{"He said “no.”", "Then left."}},
+		{"curly single quote", "It was ‘done.’ Then more.", []string{"It was ‘done.’", "Then more."}},
+		{"guillemet", "Il a dit «non.» Puis parti.", []string{"Il a dit «non.»", "Puis parti."}},
+		{"bracket", "It is (mostly so.) Then more.", []string{"It is (mostly so.)", "Then more."}},
+		{"opening quote after", "It ended. “Next” came.", []string{"It ended.", "“Next” came."}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := SplitSentences(c.text); !slices.Equal(got, c.want) {
+				t.Errorf("SplitSentences(%q) = %q, want %q", c.text, got, c.want)
+			}
+		})
+	}
+}
+
+const (
+	senA = "Machine learning is a field of study."
+	senB = "It is part of artificial intelligence."
+	senC = "The term was coined in 1959."
+	senD = "Neural networks are one approach."
+)
+
+// The parent's sentences, IDs of revision 1 unless given
+func parentSentences(texts []string, ids ...string) []*Sentence {
+	out := make([]*Sentence, len(texts))
+	for i, txt := range texts {
+		id := fmt.Sprintf("1-%d", i)
+		if i < len(ids) {
+			id = ids[i]
+		}
+		out[i] = &Sentence{ID: id, Text: txt}
+	}
+	return out
+}
+
+func TestAlignSentences(t *testing.T) {
+	cases := []struct {
+		name   string
+		parent []*Sentence
+		texts  []string
+		// IDs of the sentences of revision 2
+		ids []string
+		// Counts of added, removed, modified, moved, unchanged
+		counts [5]int
+	}{
+		{
+			name:   "unchanged",
+			parent: parentSentences([]string{senA, senB}),
+			texts:  []string{senA, senB},
+			ids:    []string{"1-0", "1-1"},
+			counts: [5]int{0, 0, 0, 0, 2},
+		},
+		{
+			name:   "page creation",
+			texts:  []string{senA, senB},
+			ids:    []string{"2-0", "2-1"},
+			counts: [5]int{2, 0, 0, 0, 0},
+		},
+		{
+			// n is the index in the revision that added it
+			name:   "added",
+			parent: parentSentences([]string{senA, senB}),
+			texts:  []string{senA, senC, senB},
+			ids:    []string{"1-0", "2-1", "1-1"},
+			counts: [5]int{1, 0, 0, 0, 2},
+		},
+		{
+			name:   "removed",
+			parent: parentSentences([]string{senA, senB, senC}),
+			texts:  []string{senA, senC},
+			ids:    []string{"1-0", "1-2"},
+			counts: [5]int{0, 1, 0, 0, 2},
+		},
+		{
+			name:   "modified",
+			parent: parentSentences([]string{senA, senB}),
+			texts:  []string{senA, "It is a part of artificial intelligence."},
+			ids:    []string{"1-0", "1-1"},
+			counts: [5]int{0, 0, 1, 0, 1},
+		},
+		{
+			name:   "rewritten",
+			parent: parentSentences([]string{senA, senB}),
+			texts:  []string{senA, senD},
+			ids:    []string{"1-0", "2-1"},
+			counts: [5]int{1, 1, 0, 0, 1},
+		},
+		{
+			name:   "moved",
+			parent: parentSentences([]string{senA, senB, senC}),
+			texts:  []string{senC, senA, senB},
+			ids:    []string{"1-2", "1-0", "1-1"},
+			counts: [5]int{0, 0, 0, 1, 2},
+		},
+		{
+			name:   "moved and modified",
+			parent: parentSentences([]string{senA, senB, senC}),
+			texts:  []string{"The term was coined in 1959 by Samuel.", senA, senB},
+			ids:    []string{"1-2", "1-0", "1-1"},
+			counts: [5]int{0, 0, 1, 0, 2},
+		},
+		{
+			// IDs come from the revision each sentence first appeared in, not the parent's
+			name:   "carried over",
+			parent: parentSentences([]string{senA, senB}, "1-0", "5-3"),
+			texts:  []string{senA, senB, senC},
+			ids:    []string{"1-0", "5-3", "2-2"},
+			counts: [5]int{1, 0, 0, 0, 2},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sentences, diffs := alignSentences(c.parent, c.texts, 2)
+			ids := []string{}
+			for i, sen := range sentences {
+				ids = append(ids, sen.ID)
+				if sen.Text != c.texts[i] {
+					t.Errorf("sentence %d is %q, want %q", i, sen.Text, c.texts[i])
+				}
+			}
+			if !slices.Equal(ids, c.ids) {
+				t.Errorf("ids %v, want %v", ids, c.ids)
+			}
+			counts := [5]int{diffs.Added, diffs.Removed, diffs.Modified, diffs.Moved, diffs.Unchanged}
+			if counts != c.counts {
+				t.Errorf("added, removed, modified, moved, unchanged %v, want %v", counts, c.counts)
+			}
+			if len(diffs.Changes) != diffs.Added+diffs.Removed+diffs.Modified+diffs.Moved {
+				t.Errorf("%d changes listed", len(diffs.Changes))
+			}
+		})
+	}
+}
+
+// Every sentence rewritten a bit, paired by similarity up to maxSimilarityPairs
+func TestAlignSentencesSimilarityCutoff(t *testing.T) {
+	rewrite := func(n int) ([]*Sentence, []string) {
+		old, texts := []string{}, []string{}
+		for i := range n {
+			old = append(old, fmt.Sprintf("Sentence number %d is here.", i))
+			texts = append(texts, fmt.Sprintf("Sentence number %d is there.", i))
+		}
+		return parentSentences(old), texts
+	}
+
+	parent, texts := rewrite(50)
+	sentences, diffs := alignSentences(parent, texts, 2)
+	if diffs.Modified != 50 || diffs.Added != 0 {
+		t.Errorf("under the cut-off: %d modified, %d added", diffs.Modified, diffs.Added)
+	}
+	if sentences[7].ID != "1-7" {
+		t.Errorf("under the cut-off: sentence 7 is %s", sentences[7].ID)
+	}
+
+	// 101 × 101 pairs
+	parent, texts = rewrite(101)
+	_, diffs = alignSentences(parent, texts, 2)
+	if diffs.Modified != 0 || diffs.Added != 101 || diffs.Removed != 101 {
+		t.Errorf("over the cut-off: %d modified, %d added, %d removed", diffs.Modified, diffs.Added, diffs.Removed)
+	}
+}
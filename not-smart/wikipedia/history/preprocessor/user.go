@@ -68,126 +68,22 @@ func reinforce(current, weight int) int {
 	return current + int((100-current)*weight/100)
 }
 
-var GroupsMap = map[GroupTag]func(*RevisionAnalysis){
-	TagBot: func(rc *RevisionAnalysis) {
-		c := 100
-		rc.Confidence.Automation = reinforce(rc.Confidence.Automation, c)
-		rc.Tags.IsBot = true
-	},
-	TagGlobalBot: func(rc *RevisionAnalysis) {
-		c := 80
-		rc.Confidence.Automation = reinforce(rc.Confidence.Automation, c)
-	},
-	TagFlood: func(rc *RevisionAnalysis) {
-		c := 60
-		rc.Confidence.Automation = reinforce(rc.Confidence.Automation, c)
-	},
-	TagTemplateEditor: func(rc *RevisionAnalysis) {
-		c := 60
-		rc.Confidence.Automation = reinforce(rc.Confidence.Automation, c)
-	},
-	//
-	TagUser: func(rc *RevisionAnalysis) {
-		c := 85
-		rc.Confidence.Human = reinforce(rc.Confidence.Human, c)
-	},
-	TagExtendedConfirmed: func(rc *RevisionAnalysis) {
-		c := 90
-		rc.Confidence.Human = reinforce(rc.Confidence.Human, c)
-	},
-	TagAutoConfirmed: func(rc *RevisionAnalysis) {
-		c := 85
-		rc.Confidence.Human = reinforce(rc.Confidence.Human, c)
-	},
-	TagConfirmed: func(rc *RevisionAnalysis) {
-		c := 85
-		rc.Confidence.Human = reinforce(rc.Confidence.Human, c)
-	},
-	TagReviewer: func(rc *RevisionAnalysis) {
-		c := 80
-		rc.Confidence.Human = reinforce(rc.Confidence.Human, c)
-	},
-	TagAutoReviewer: func(rc *RevisionAnalysis) {
-		c := 80
-		rc.Confidence.Human = reinforce(rc.Confidence.Human, c)
-	},
-	TagEditor: func(rc *RevisionAnalysis) {
-		c := 70
-		rc.Confidence.Human = reinforce(rc.Confidence.Human, c)
-	},
-	TagResearcher: func(rc *RevisionAnalysis) {
-		c := 60
-		rc.Confidence.Human = reinforce(rc.Confidence.Human, c)
-	},
-	TagAbuseFilter: func(rc *RevisionAnalysis) {
-		c := 60
-		rc.Confidence.Human = reinforce(rc.Confidence.Human, c)
-	},
-	TagTemp: func(rc *RevisionAnalysis) {
-		c := 60
-		rc.Confidence.Human = reinforce(rc.Confidence.Human, c)
-	},
-	TagTempAccViewer: func(rc *RevisionAnalysis) {
-		c := 75
-		rc.Confidence.Human = reinforce(rc.Confidence.Human, c)
-	},
-	TagIPBlockExempty: func(rc *RevisionAnalysis) {
-		c := 65
-		rc.Confidence.Human = reinforce(rc.Confidence.Human, c)
-	},
-	//
-	TagSysOp: func(rc *RevisionAnalysis) {
-		c := 80
-		rc.Confidence.Structural = reinforce(rc.Confidence.Structural, c)
-	},
-	TagGlobalSysOp: func(rc *RevisionAnalysis) {
-		c := 90
-		rc.Confidence.Structural = reinforce(rc.Confidence.Structural, c)
-	},
-	//
-	TagBureaucrat: func(rc *RevisionAnalysis) {
-		c := 80
-		rc.Confidence.Maintenance = reinforce(rc.Confidence.Maintenance, c)
-	},
-	TagCheckUser: func(rc *RevisionAnalysis) {
-		c := 80
-		rc.Confidence.Maintenance = reinforce(rc.Confidence.Maintenance, c)
-	},
-	TagRollBacker: func(rc *RevisionAnalysis) {
-		c := 80
-		rc.Confidence.Maintenance = reinforce(rc.Confidence.Maintenance, c)
-	},
-	TagPatroller: func(rc *RevisionAnalysis) {
-		c := 70
-		rc.Confidence.Maintenance = reinforce(rc.Confidence.Maintenance, c)
-	},
-	TagExtendedMover: func(rc *RevisionAnalysis) {
-		c := 65
-		rc.Confidence.Maintenance = reinforce(rc.Confidence.Maintenance, c)
-	},
-	TagFileMover: func(rc *RevisionAnalysis) {
-		c := 50
-		rc.Confidence.Maintenance = reinforce(rc.Confidence.Maintenance, c)
-	},
-}
-
-var IgnoreGroupMap = map[GroupTag]bool{
-	TagAsterisk: true,
-}
-
 type UserAnalyzer struct {
 	// In       chan *RevisionMeta
 	// Out      chan error
+	rules *Rules
+
 	ctx      context.Context
 	metrics  *Metrics
 	debugger *debugger.Debugger
 }
 
-func NewUserAnalyzer(commons *Commons) *UserAnalyzer {
+func NewUserAnalyzer(commons *Commons, rules *Rules) *UserAnalyzer {
 	// func NewUserAnalyzer(commons *Commons, in chan *RevisionMeta, out chan error) *UserAnalyzer {
 	return &UserAnalyzer{
 		// In:       in,
 		// Out:      out,
+		rules:    rules,
 		ctx:      commons.ctx,
 		metrics:  commons.metrics,
 		debugger: commons.debugger,
@@ -195,19 +91,36 @@ func NewUserAnalyzer(commons *Commons) *UserAnalyzer {
 }
 
 func (s *UserAnalyzer) analyzeUser(ra *RevisionAnalysis) error {
-	for _, flag := range ra.Process.User.Groups {
-		if set, ok := GroupsMap[GroupTag(flag)]; ok {
-			set(ra)
-		} else {
-			if exempt, ok := IgnoreGroupMap[GroupTag(flag)]; ok {
-				if !exempt {
-					s.debugger.Debug(fmt.Sprintf("'%s' not in groups map and unexempted", flag))
-				}
-			} else {
-				s.debugger.Debug(fmt.Sprintf("'%s' not in groups map and ignore map", flag))
-			}
+	// Not the groups held today, an admin's edits from before they were one aren't an admin's
+	ra.Trust.Groups, ra.Trust.GroupsCurrent = groupsAt(ra.Process.User, ra.Process.Meta.TimeStamp)
+	for _, flag := range ra.Trust.Groups {
+		if !s.rules.apply(ra, GroupTag(flag), ra.Trust.GroupsCurrent) && !s.rules.ignored(GroupTag(flag)) {
+			s.debugger.Debug(fmt.Sprintf("'%s' not in the group rules and not ignored", flag))
 		}
 	}
 
+	s.analyzeTrust(ra)
+
 	return nil
 }
+
+// Tenure, edit count and history on the article, on top of the groups.
+// An IP has no registration nor edit count, only its history on the article counts.
+func (s *UserAnalyzer) analyzeTrust(ra *RevisionAnalysis) {
+	user := ra.Process.User
+	trust := ra.Trust
+	trust.AccountAgeDays = -1
+	trust.EditCount = user.EditCount
+	trust.ArticleEdits = ra.Process.ArticleEdits
+
+	// Accounts older than 2005-12 have no registration
+	if !user.Registration.IsZero() {
+		age := ra.Process.Meta.TimeStamp.Sub(user.Registration)
+		trust.AccountAgeDays = max(int(age.Hours()/24), 0)
+		applyBucket(ra, "tenure", s.rules.Tenure, trust.AccountAgeDays)
+	}
+	if user.UserID != 0 {
+		applyBucket(ra, "editcount", s.rules.EditCount, user.EditCount)
+	}
+	applyBucket(ra, "article", s.rules.ArticleEdits, trust.ArticleEdits)
+}
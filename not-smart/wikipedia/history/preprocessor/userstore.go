@@ -0,0 +1,318 @@
+package preprocessor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The user store of a wiki, next to the dumps of its titles
+const UserStoreFile = "0users.jsonl"
+
+// Users fetched longer ago than this are fetched again, their groups and edit count change
+const DefaultUserTTL = 30 * 24 * time.Hour
+
+// Compacted when opened, if no other run has it open, once it has this many times more lines than users
+const userStoreCompactRatio = 2
+
+// The users of a wiki, shared by every article processed, so a prolific editor is fetched
+// once and not once per article. Users are appended to the file as they're fetched, one
+// line each in a single write, a later line of a user replaces the earlier ones.
+// Several runs can append to the same file, each only sees the others' users once reopened.
+// Each holds a shared flock on it while it's open, it's only compacted by a run that gets
+// it for itself, and a run that opened it just before it was compacted opens it again.
+type UserStore struct {
+	fPath string
+	// 0 never expires
+	ttl time.Duration
+	// Users fetched before are stale whatever the ttl, set to the opening time to refresh everyone
+	refreshBefore time.Time
+	openedAt      time.Time
+
+	mu    sync.RWMutex
+	f     *os.File
+	users map[int]*UserData
+}
+
+func OpenUserStore(fPath string, ttl time.Duration, refresh bool) (*UserStore, error) {
+	if ttl < 0 {
+		return nil, fmt.Errorf("user ttl can't be negative")
+	}
+	if err := os.MkdirAll(filepath.Dir(fPath), 0700); err != nil {
+		return nil, err
+	}
+
+	f, users, err := openLocked(fPath)
+	if err != nil {
+		return nil, err
+	}
+	// A crash cut the last line short, the next one starts on its own line.
+	// Not truncated, another run could be writing to it.
+	if err = endLine(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	store := &UserStore{
+		fPath:    fPath,
+		ttl:      ttl,
+		f:        f,
+		users:    users,
+		openedAt: time.Now(),
+	}
+	if refresh {
+		store.refreshBefore = store.openedAt
+	}
+	return store, nil
+}
+
+// The user whether or not it's stale, a stale one is better than none if it can't be fetched again
+func (s *UserStore) Get(userID int) (*UserData, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[userID]
+	return user, ok
+}
+
+// Whether the user is stored and doesn't have to be fetched again
+func (s *UserStore) Fresh(userID int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[userID]
+	return ok && !s.stale(user)
+}
+
+func (s *UserStore) stale(user *UserData) bool {
+	// Stored before rights logs were kept, or it couldn't be fetched by an earlier run.
+	// A failure of this run isn't retried in every batch.
+	if needsRights(user) && !user.RightsFetched && user.RightsFailedAt.Before(s.openedAt) {
+		return true
+	}
+	if user.FetchedAt.Before(s.refreshBefore) {
+		return true
+	}
+	return s.ttl > 0 && time.Since(user.FetchedAt) > s.ttl
+}
+
+// Stores the users just fetched and appends them to the file
+func (s *UserStore) Put(users []*UserData) error {
+	now := time.Now().UTC()
+	for _, user := range users {
+		user.FetchedAt = now
+	}
+	return s.append(users, true)
+}
+
+// Adds users from elsewhere, e.g. a per article cache, the stored ones are kept
+func (s *UserStore) Import(users []*UserData) error {
+	return s.append(users, false)
+}
+
+// One write for all of them, so the lines of another run don't end up in between
+func (s *UserStore) append(users []*UserData, replace bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, user := range users {
+		if _, ok := s.users[user.UserID]; ok && !replace {
+			continue
+		}
+		line, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		s.users[user.UserID] = user
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	_, err := s.f.Write(buf.Bytes())
+	return err
+}
+
+func (s *UserStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.users)
+}
+
+func (s *UserStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.f.Sync(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+// Opens the file with a shared lock and reads it, compacting it first if no other run has it open
+func openLocked(fPath string) (*os.File, map[int]*UserData, error) {
+	for {
+		f, err := os.OpenFile(fPath, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		compacted, err := compactAlone(f, fPath)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		if compacted {
+			// f is the file before, the compacted one is opened again
+			f.Close()
+			continue
+		}
+
+		// Other runs can append from here on, not compact
+		if err = lockShared(f); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		same, err := sameFile(f, fPath)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		if !same {
+			// Compacted by another run before the lock was taken
+			f.Close()
+			continue
+		}
+
+		users, _, err := readUserStore(fPath)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return f, users, nil
+	}
+}
+
+// Rewrites the file if no other run has it open and it's worth it
+func compactAlone(f *os.File, fPath string) (bool, error) {
+	alone, err := tryLockExclusive(f)
+	if err != nil || !alone {
+		return false, err
+	}
+	if same, err := sameFile(f, fPath); err != nil || !same {
+		return false, err
+	}
+	users, lines, err := readUserStore(fPath)
+	if err != nil {
+		return false, err
+	}
+	if lines <= userStoreCompactRatio*len(users) || lines <= 100 {
+		return false, nil
+	}
+	// Still locked, whoever opened it meanwhile waits and then sees it moved
+	return true, writeUserStore(fPath, users)
+}
+
+// Whether f is still the file at fPath, it isn't once another run compacted it
+func sameFile(f *os.File, fPath string) (bool, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	pathInfo, err := os.Stat(fPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return os.SameFile(info, pathInfo), nil
+}
+
+// The users of the file, the last line of each wins, and how many lines it has
+func readUserStore(fPath string) (map[int]*UserData, int, error) {
+	users := make(map[int]*UserData)
+
+	f, err := os.Open(fPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return users, 0, nil
+		}
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	lines := 0
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines++
+			user := new(UserData)
+			// A cut off line is skipped
+			if json.Unmarshal(line, user) == nil {
+				// Stored before kinds were kept
+				if user.Kind == "" {
+					setFetchedKind(user)
+				}
+				users[user.UserID] = user
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, 0, err
+		}
+	}
+
+	return users, lines, nil
+}
+
+// Rewrites the file with a line per user, through a temporary file so it's never half written
+func writeUserStore(fPath string, users map[int]*UserData) error {
+	var buf bytes.Buffer
+	for _, user := range users {
+		line, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	tmp := fPath + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fPath)
+}
+
+// Writes a newline if the file doesn't end with one
+func endLine(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err = f.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	_, err = f.Write([]byte{'\n'})
+	return err
+}
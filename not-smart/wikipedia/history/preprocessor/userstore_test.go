@@ -0,0 +1,181 @@
+package preprocessor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openStore(t *testing.T, fPath string) *UserStore {
+	t.Helper()
+
+	store, err := OpenUserStore(fPath, DefaultUserTTL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func countStoreLines(t *testing.T, fPath string) int {
+	t.Helper()
+
+	data, err := os.ReadFile(fPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+// lines lines over users users, the last line of each has its final edit count
+func writeBloatedStore(t *testing.T, fPath string, users, lines int) {
+	t.Helper()
+
+	f, err := os.OpenFile(fPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i := range lines {
+		line, err := json.Marshal(&UserData{UserID: i%users + 1, Name: fmt.Sprintf("U%d", i%users+1), EditCount: i, Kind: EditorTemporary})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(append(line, '\n'))
+	}
+}
+
+func TestUserStoreReopen(t *testing.T) {
+	fPath := filepath.Join(t.TempDir(), UserStoreFile)
+
+	store := openStore(t, fPath)
+	if err := store.Put([]*UserData{{UserID: 1, Name: "Alice", EditCount: 10, Kind: EditorTemporary}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put([]*UserData{{UserID: 1, Name: "Alice", EditCount: 11, Kind: EditorTemporary}}); err != nil {
+		t.Fatal(err)
+	}
+	// Kept, not replaced by an import
+	if err := store.Import([]*UserData{{UserID: 1, Name: "Alice", EditCount: 1}, {UserID: 2, Name: "Bob", Kind: EditorTemporary}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store = openStore(t, fPath)
+	defer store.Close()
+	if store.Len() != 2 {
+		t.Fatalf("%d users, want 2", store.Len())
+	}
+	if user, _ := store.Get(1); user.EditCount != 11 || user.FetchedAt.IsZero() {
+		t.Errorf("Alice has %d edits, fetched at %v", user.EditCount, user.FetchedAt)
+	}
+	if !store.Fresh(1) || store.Fresh(3) {
+		t.Error("wrong freshness")
+	}
+}
+
+func TestUserStoreCompactsAlone(t *testing.T) {
+	fPath := filepath.Join(t.TempDir(), UserStoreFile)
+	writeBloatedStore(t, fPath, 10, 150)
+
+	store := openStore(t, fPath)
+	defer store.Close()
+	if n := countStoreLines(t, fPath); n != 10 {
+		t.Errorf("%d lines once compacted, want 10", n)
+	}
+	if user, _ := store.Get(10); user.EditCount != 149 {
+		t.Errorf("user 10 has %d edits, want the last line's 149", user.EditCount)
+	}
+}
+
+// A run that has the file open keeps it from being compacted under it
+func TestUserStoreNoCompactionWhileShared(t *testing.T) {
+	fPath := filepath.Join(t.TempDir(), UserStoreFile)
+
+	first := openStore(t, fPath)
+	writeBloatedStore(t, fPath, 10, 150)
+
+	second := openStore(t, fPath)
+	if n := countStoreLines(t, fPath); n != 150 {
+		t.Fatalf("compacted with another run appending, %d lines", n)
+	}
+
+	// Both appends land in the file
+	if err := first.Put([]*UserData{{UserID: 100, Name: "First", Kind: EditorTemporary}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Put([]*UserData{{UserID: 200, Name: "Second", Kind: EditorTemporary}}); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+	second.Close()
+
+	// Alone now, compacted
+	third := openStore(t, fPath)
+	defer third.Close()
+	if n := countStoreLines(t, fPath); n != 12 {
+		t.Errorf("%d lines once compacted, want 12", n)
+	}
+	for _, userID := range []int{100, 200} {
+		if _, ok := third.Get(userID); !ok {
+			t.Errorf("user %d is lost", userID)
+		}
+	}
+}
+
+func TestSameFileAfterCompaction(t *testing.T) {
+	fPath := filepath.Join(t.TempDir(), UserStoreFile)
+	writeBloatedStore(t, fPath, 1, 1)
+
+	f, err := os.Open(fPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if same, err := sameFile(f, fPath); err != nil || !same {
+		t.Fatalf("same %t, %v", same, err)
+	}
+	users, _, err := readUserStore(fPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writeUserStore(fPath, users); err != nil {
+		t.Fatal(err)
+	}
+	if same, err := sameFile(f, fPath); err != nil || same {
+		t.Errorf("same %t after the file was replaced, %v", same, err)
+	}
+}
+
+// A failed rights log is asked for again by the next run, not by every batch of this one
+func TestUserStoreRightsFailed(t *testing.T) {
+	fPath := filepath.Join(t.TempDir(), UserStoreFile)
+	store := openStore(t, fPath)
+
+	failed := &UserData{UserID: 1, Name: "A", Kind: EditorRegistered, RightsFailedAt: time.Now().UTC()}
+	legacy := &UserData{UserID: 2, Name: "B", Kind: EditorRegistered}
+	if err := store.Put([]*UserData{failed, legacy}); err != nil {
+		t.Fatal(err)
+	}
+	if !store.Fresh(1) {
+		t.Error("failed in this run, stale")
+	}
+	// Stored before rights logs were kept
+	if store.Fresh(2) {
+		t.Error("without rights, fresh")
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store = openStore(t, fPath)
+	defer store.Close()
+	if store.Fresh(1) {
+		t.Error("failed in an earlier run, fresh")
+	}
+}
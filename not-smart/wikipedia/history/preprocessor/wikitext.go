@@ -0,0 +1,205 @@
+package preprocessor
+
+import (
+	"context"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Renders wikitext to plain text in-process, no pandoc needed.
+// It's not a MediaWiki parser, it strips what carries no prose:
+// comments, refs, templates, files, categories, table and text markup.
+type NativeBackend struct{}
+
+func NewNativeBackend() *NativeBackend {
+	return &NativeBackend{}
+}
+
+func (s *NativeBackend) Clean(ctx context.Context, wikitext string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return WikitextToPlain(wikitext), nil
+}
+
+func (s *NativeBackend) Close() error {
+	return nil
+}
+
+var (
+	reComment = regexp.MustCompile(`(?s)<!--.*?(-->|$)`)
+	reRef     = regexp.MustCompile(`(?is)<ref\b[^>/]*(/>|>.*?</ref\s*>)`)
+	// Tags whose content isn't prose
+	reDropTags = regexp.MustCompile(`(?is)<(references|gallery|math|chem|score|timeline|graph|mapframe|templatedata|imagemap)\b[^>]*?(/>|>.*?</(references|gallery|math|chem|score|timeline|graph|mapframe|templatedata|imagemap)\s*>)`)
+	reBr       = regexp.MustCompile(`(?i)<br\s*/?>`)
+	reTag      = regexp.MustCompile(`</?[a-zA-Z][a-zA-Z0-9]*\b[^<>]*>`)
+
+	reTemplateParam = regexp.MustCompile(`\{\{\{[^{}]*\}\}\}`)
+	reTemplate      = regexp.MustCompile(`\{\{[^{}]*\}\}`)
+	// Innermost first, so a file caption's links are gone before the file is
+	reLink     = regexp.MustCompile(`\[\[([^\[\]]*)\]\]`)
+	reExtLink  = regexp.MustCompile(`\[(?:https?:|ftp:)?//[^\s\]]*(?:\s+([^\]]*))?\]`)
+	reBoldItal = regexp.MustCompile(`'{2,5}`)
+	reMagic    = regexp.MustCompile(`__[A-Z]+__`)
+	reHeading  = regexp.MustCompile(`^(={1,6})\s*(.*?)\s*={1,6}\s*$`)
+	reListMark = regexp.MustCompile(`^[*#:;]+\s*`)
+	reSpaces   = regexp.MustCompile(`[ \t\x{00A0}]+`)
+)
+
+// Namespaces of links that don't show up as text, in English and as the canonical names
+var droppedLinkPrefixes = []string{"file:", "image:", "category:", "media:"}
+
+func WikitextToPlain(txt string) string {
+	txt = reComment.ReplaceAllString(txt, "")
+	txt = reRef.ReplaceAllString(txt, "")
+	txt = reDropTags.ReplaceAllString(txt, "")
+	txt = reMagic.ReplaceAllString(txt, "")
+
+	txt = replaceUntilStable(txt, reTemplateParam, func(string) string { return "" })
+	txt = replaceUntilStable(txt, reTemplate, func(string) string { return "" })
+	txt = replaceUntilStable(txt, reLink, renderLink)
+	txt = reExtLink.ReplaceAllString(txt, "$1")
+
+	txt = renderTables(txt)
+
+	txt = reBr.ReplaceAllString(txt, "\n")
+	txt = reTag.ReplaceAllString(txt, "")
+	txt = reBoldItal.ReplaceAllString(txt, "")
+
+	lines := strings.Split(txt, "\n")
+	out := make([]string, 0, len(lines))
+	blank := true
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if m := reHeading.FindStringSubmatch(line); m != nil {
+			line = m[2]
+		} else if strings.HasPrefix(line, "----") {
+			line = ""
+		} else {
+			line = reListMark.ReplaceAllString(line, "")
+		}
+		line = strings.TrimSpace(reSpaces.ReplaceAllString(html.UnescapeString(line), " "))
+
+		// One blank line between paragraphs at most
+		if line == "" {
+			if !blank {
+				out = append(out, "")
+			}
+			blank = true
+			continue
+		}
+		out = append(out, line)
+		blank = false
+	}
+
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+func replaceUntilStable(txt string, re *regexp.Regexp, fn func(string) string) string {
+	// Nesting deeper than this is broken markup, what's left stays as is
+	for range 20 {
+		next := re.ReplaceAllStringFunc(txt, fn)
+		if next == txt {
+			break
+		}
+		txt = next
+	}
+	return txt
+}
+
+// [[target|label]] is the label, [[target]] the target, files and categories are dropped
+func renderLink(link string) string {
+	inner := strings.TrimSpace(link[2 : len(link)-2])
+	lower := strings.ToLower(strings.TrimPrefix(inner, ":"))
+	for _, prefix := range droppedLinkPrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			// [[:Category:X]] links to the category page and shows up
+			if strings.HasPrefix(inner, ":") && prefix == "category:" {
+				break
+			}
+			return ""
+		}
+	}
+	// Interlanguage links, [[fr:Apprentissage automatique]]
+	if prefix, _, ok := strings.Cut(inner, ":"); ok && isLangCode(prefix) {
+		return ""
+	}
+
+	target, label, ok := strings.Cut(inner, "|")
+	if ok {
+		// The pipe trick, [[Paris, France|]], shows the target without the qualifier
+		if label == "" {
+			label, _, _ = strings.Cut(target, ",")
+			label, _, _ = strings.Cut(label, " (")
+		}
+		return label
+	}
+	target, _, _ = strings.Cut(target, "#")
+	return strings.TrimPrefix(target, ":")
+}
+
+func isLangCode(str string) bool {
+	if len(str) < 2 || len(str) > 3 {
+		return false
+	}
+	for _, r := range str {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Keeps the text of every cell, one per line, drops the table markup and attributes
+func renderTables(txt string) string {
+	if !strings.Contains(txt, "{|") {
+		return txt
+	}
+
+	lines := strings.Split(txt, "\n")
+	out := make([]string, 0, len(lines))
+	depth := 0
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "{|"):
+			depth++
+			continue
+		case depth > 0 && strings.HasPrefix(trimmed, "|}"):
+			depth--
+			continue
+		case depth == 0:
+			out = append(out, line)
+			continue
+		case strings.HasPrefix(trimmed, "|-"):
+			continue
+		case strings.HasPrefix(trimmed, "|+"):
+			out = append(out, cellText(trimmed[2:]))
+			continue
+		case strings.HasPrefix(trimmed, "|"), strings.HasPrefix(trimmed, "!"):
+			sep := "||"
+			if trimmed[0] == '!' {
+				sep = "!!"
+			}
+			for _, cell := range strings.Split(trimmed[1:], sep) {
+				if cell = cellText(cell); cell != "" {
+					out = append(out, cell)
+				}
+			}
+		default:
+			// A cell's text continued on the next line
+			out = append(out, line)
+		}
+	}
+
+	return strings.Join(out, "\n")
+}
+
+// Links are already rendered, so a pipe left in a cell ends its attributes
+func cellText(cell string) string {
+	if _, content, ok := strings.Cut(cell, "|"); ok {
+		cell = content
+	}
+	return strings.TrimSpace(cell)
+}
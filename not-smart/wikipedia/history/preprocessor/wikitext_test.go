@@ -0,0 +1,68 @@
+package preprocessor
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata/clean")
+
+// testdata/clean/*.wiki and the plain text NativeBackend makes of them, *.txt
+func cleanGoldens(t *testing.T) []string {
+	t.Helper()
+
+	inputs, err := filepath.Glob(filepath.Join("testdata", "clean", "*.wiki"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no testdata/clean/*.wiki")
+	}
+	return inputs
+}
+
+func readFile(t *testing.T, fPath string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(fPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestNativeBackendGolden(t *testing.T) {
+	backend := NewNativeBackend()
+	for _, input := range cleanGoldens(t) {
+		name := strings.TrimSuffix(filepath.Base(input), ".wiki")
+		t.Run(name, func(t *testing.T) {
+			got, err := backend.Clean(context.Background(), readFile(t, input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := strings.TrimSuffix(input, ".wiki") + ".txt"
+			if *update {
+				if err = os.WriteFile(golden, []byte(got+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if want := strings.TrimSuffix(readFile(t, golden), "\n"); got != want {
+				t.Errorf("got:\n%s\n\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestNativeBackendCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewNativeBackend().Clean(ctx, "text"); err == nil {
+		t.Error("cleaned with a canceled context")
+	}
+}
@@ -0,0 +1,215 @@
+package history
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A MediaWiki action=query request
+type Query interface {
+	Params() url.Values
+}
+
+// The "continue" block of a response, merged as is into the next request
+type Continuation map[string]string
+
+// Parameters every query shares
+func baseParams() url.Values {
+	baseQueries := map[string]string{
+		"action":        "query",
+		"format":        "json",
+		"formatversion": "2",
+	}
+	params := url.Values{}
+	for key, val := range baseQueries {
+		params.Set(key, val)
+	}
+	return params
+}
+
+func joinInts(ids []int) string {
+	var sb strings.Builder
+	for i, id := range ids {
+		if i > 0 {
+			sb.WriteByte('|')
+		}
+		sb.WriteString(strconv.Itoa(id))
+	}
+	return sb.String()
+}
+
+func setIf(params url.Values, key, val string) {
+	if val != "" {
+		params.Set(key, val)
+	}
+}
+
+// >>>>>
+
+// Resolves titles, following redirects
+type TitlesQuery struct {
+	Titles []string
+}
+
+func (q *TitlesQuery) Params() url.Values {
+	params := baseParams()
+	params.Set("titles", strings.Join(q.Titles, "|"))
+	params.Set("redirects", "1")
+	return params
+}
+
+// >>>>>
+
+// prop=revisions, either the history of one page or a batch of revision IDs'
+type RevisionsQuery struct {
+	PageID int
+	RevIDs []int
+
+	// rvprop values
+	Props []string
+	Slots string
+	// "max" or a number, only valid for a single page
+	Limit string
+
+	// Where the listing starts, newest first unless Dir is "newer"
+	StartID int
+	Start   time.Time
+	End     time.Time
+	Dir     string
+}
+
+func (q *RevisionsQuery) Params() url.Values {
+	params := baseParams()
+	params.Set("prop", "revisions")
+	if q.PageID != 0 {
+		params.Set("pageids", strconv.Itoa(q.PageID))
+		params.Set("redirects", "1")
+	}
+	if len(q.RevIDs) > 0 {
+		params.Set("revids", joinInts(q.RevIDs))
+	}
+	setIf(params, "rvprop", strings.Join(q.Props, "|"))
+	setIf(params, "rvslots", q.Slots)
+	setIf(params, "rvlimit", q.Limit)
+	if q.StartID != 0 {
+		params.Set("rvstartid", strconv.Itoa(q.StartID))
+	}
+	if !q.Start.IsZero() {
+		params.Set("rvstart", q.Start.UTC().Format(time.RFC3339))
+	}
+	if !q.End.IsZero() {
+		params.Set("rvend", q.End.UTC().Format(time.RFC3339))
+	}
+	setIf(params, "rvdir", q.Dir)
+	return params
+}
+
+// >>>>>
+
+// list=users
+type UsersQuery struct {
+	UserIDs []int
+	Names   []string
+	// usprop values
+	Props []string
+}
+
+func (q *UsersQuery) Params() url.Values {
+	params := baseParams()
+	params.Set("list", "users")
+	if len(q.UserIDs) > 0 {
+		params.Set("ususerids", joinInts(q.UserIDs))
+	}
+	setIf(params, "ususers", strings.Join(q.Names, "|"))
+	setIf(params, "usprop", strings.Join(q.Props, "|"))
+	return params
+}
+
+// >>>>>
+
+// list=logevents
+type LogEventsQuery struct {
+	// "rights", "block"...
+	Type string
+	// The page the events are about, User:<name> for the rights log
+	Title string
+	// leprop values
+	Props []string
+	Limit string
+	// "newer" for the oldest first
+	Dir string
+}
+
+func (q *LogEventsQuery) Params() url.Values {
+	params := baseParams()
+	params.Set("list", "logevents")
+	setIf(params, "letype", q.Type)
+	setIf(params, "letitle", q.Title)
+	setIf(params, "leprop", strings.Join(q.Props, "|"))
+	setIf(params, "lelimit", q.Limit)
+	setIf(params, "ledir", q.Dir)
+	return params
+}
+
+// >>>>>
+
+// list=search
+type SearchQuery struct {
+	Search    string
+	Namespace int
+	Limit     string
+	// "text" or "title"
+	What string
+}
+
+func (q *SearchQuery) Params() url.Values {
+	params := baseParams()
+	params.Set("list", "search")
+	params.Set("srsearch", q.Search)
+	params.Set("srnamespace", strconv.Itoa(q.Namespace))
+	setIf(params, "srlimit", q.Limit)
+	setIf(params, "srwhat", q.What)
+	return params
+}
+
+// >>>>>
+
+// prop=links, the outgoing links of a page
+type LinksQuery struct {
+	Titles    []string
+	Namespace int
+	Limit     string
+}
+
+func (q *LinksQuery) Params() url.Values {
+	params := baseParams()
+	params.Set("prop", "links")
+	params.Set("titles", strings.Join(q.Titles, "|"))
+	params.Set("plnamespace", strconv.Itoa(q.Namespace))
+	setIf(params, "pllimit", q.Limit)
+	return params
+}
+
+// >>>>>
+
+// list=categorymembers
+type CategoryMembersQuery struct {
+	// With the "Category:" prefix
+	Title      string
+	Namespaces []int
+	// "page", "subcat" or "file", joined
+	Types []string
+	Limit string
+}
+
+func (q *CategoryMembersQuery) Params() url.Values {
+	params := baseParams()
+	params.Set("list", "categorymembers")
+	params.Set("cmtitle", q.Title)
+	setIf(params, "cmnamespace", joinInts(q.Namespaces))
+	setIf(params, "cmtype", strings.Join(q.Types, "|"))
+	setIf(params, "cmlimit", q.Limit)
+	return params
+}
@@ -0,0 +1,241 @@
+package scraper
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"evolve/debugger"
+	"evolve/wikipedia/history"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Reads one title per line, skipping blank lines and "#" comments
+func ReadTitlesFile(fPath string) ([]string, error) {
+	f, err := os.Open(fPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	titles := make([]string, 0)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		titles = append(titles, line)
+	}
+
+	return titles, sc.Err()
+}
+
+// Lists the articles of a category, descending depth levels of sub categories.
+// Depth 0 is only the category itself.
+func CategoryTitles(ctx context.Context, client *history.Client, category string, depth int) ([]string, error) {
+	if !strings.HasPrefix(category, "Category:") {
+		category = "Category:" + category
+	}
+
+	titles := make([]string, 0)
+	seenTitles := make(map[string]bool)
+	seenCats := map[string]bool{category: true}
+	level := []string{category}
+
+	for d := 0; d <= depth && len(level) > 0; d++ {
+		next := make([]string, 0)
+		for _, cat := range level {
+			query := &history.CategoryMembersQuery{
+				Title:      cat,
+				Namespaces: []int{0, 14},
+				Types:      []string{"page", "subcat"},
+				Limit:      "max",
+			}
+			err := history.Paginate(ctx, client, query, nil, func(page *CategoryMembers, _ history.Continuation) error {
+				if page.Query == nil {
+					return nil
+				}
+				for _, member := range page.Query.CategoryMembers {
+					switch member.NameSpace {
+					case 0:
+						if !seenTitles[member.Title] {
+							seenTitles[member.Title] = true
+							titles = append(titles, member.Title)
+						}
+					case 14:
+						if !seenCats[member.Title] {
+							seenCats[member.Title] = true
+							next = append(next, member.Title)
+						}
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("%s members: %w", cat, err)
+			}
+		}
+		level = next
+	}
+
+	return titles, nil
+}
+
+// Batch scrapes several pages, at most parallel at a time.
+// They share the client, so its rate limit is spread over all of them.
+// A failing page doesn't stop the others.
+type Batch struct {
+	titles      []string
+	rootDumpDir string
+	parallel    int
+	opts        *Options
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Closed once every page returned
+	done chan struct{}
+
+	mu       sync.Mutex
+	scrapers map[string]*Scraper
+	failed   map[string]error
+
+	client   *history.Client
+	debugger *debugger.Debugger
+}
+
+func NewBatch(titles []string, rootDumpDir string, parallel int, opts *Options, client *history.Client, debugger *debugger.Debugger) *Batch {
+	if parallel <= 0 {
+		parallel = 1
+	}
+	return &Batch{
+		titles:      titles,
+		rootDumpDir: rootDumpDir,
+		parallel:    parallel,
+		opts:        opts,
+		scrapers:    make(map[string]*Scraper),
+		failed:      make(map[string]error),
+		client:      client,
+		debugger:    debugger,
+	}
+}
+
+func (s *Batch) Run() error {
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		sem := make(chan struct{}, s.parallel)
+		wg := sync.WaitGroup{}
+	outer:
+		for _, title := range s.titles {
+			select {
+			case <-s.ctx.Done():
+				break outer
+			case sem <- struct{}{}:
+			}
+			wg.Go(func() {
+				defer func() { <-sem }()
+				if err := s.scrape(title); err != nil {
+					s.mu.Lock()
+					s.failed[title] = err
+					s.mu.Unlock()
+					s.debugger.Print("\n%s FAILED: %v\n", title, err)
+				}
+			})
+		}
+		wg.Wait()
+	}()
+
+	return nil
+}
+
+func (s *Batch) scrape(title string) error {
+	sc, err := NewWikiScrape(title, s.rootDumpDir, s.opts, s.client, s.debugger)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.scrapers[title] = sc
+	s.mu.Unlock()
+
+	if err = sc.Run(); err != nil {
+		return err
+	}
+
+	select {
+	case <-sc.Done():
+		return sc.Wait()
+	case <-s.ctx.Done():
+		return sc.Stop()
+	}
+}
+
+// Done is closed once every page is scraped, or the batch was stopped
+func (s *Batch) Done() <-chan struct{} {
+	return s.done
+}
+
+// Wait blocks until the batch is over, returns the failed pages joined
+func (s *Batch) Wait() error {
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	errs := make([]error, 0, len(s.failed))
+	for _, title := range s.titles {
+		if err, ok := s.failed[title]; ok {
+			errs = append(errs, fmt.Errorf("%s: %w", title, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Stop cancels every running page and skips the ones not started yet
+func (s *Batch) Stop() error {
+	s.cancel()
+
+	return s.Wait()
+}
+
+// The titles that were scraped without an error
+func (s *Batch) Completed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	titles := make([]string, 0, len(s.scrapers))
+	for _, title := range s.titles {
+		if _, ok := s.scrapers[title]; !ok {
+			continue
+		}
+		if _, failed := s.failed[title]; !failed {
+			titles = append(titles, title)
+		}
+	}
+	return titles
+}
+
+func (s *Batch) PrintMetrics() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Printf("\n\n")
+	fmt.Printf("Metrics:\n")
+
+	all := make(map[string]*Metrics, len(s.scrapers))
+	for title, sc := range s.scrapers {
+		all[title] = sc.metrics
+	}
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", string(data))
+
+	return nil
+}
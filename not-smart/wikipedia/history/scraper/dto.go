@@ -1,6 +1,9 @@
 package scraper
 
-import "time"
+import (
+	"evolve/wikipedia/history"
+	"time"
+)
 
 // >>>>>
 
@@ -14,11 +17,7 @@ type DebugWarnings struct {
 	JSON DebugWarningsJSON `json:"json"`
 	Main DebugWarningsMain `json:"main"`
 }
-type DebugError struct {
-	Code          string `json:"code"`
-	Info          string `json:"info"`
-	DebugWildcard string `json:"*"`
-}
+type DebugError = history.APIError
 
 // Common Debug Info
 type Debug struct {
@@ -37,6 +36,8 @@ type ResolvePage struct {
 	PageID    int    `json:"pageid"`
 	NameSpace int    `json:"ns"`
 	Title     string `json:"title"`
+	Missing   bool   `json:"missing"`
+	Invalid   bool   `json:"invalid"`
 }
 
 type ResolveQuery struct {
@@ -51,6 +52,14 @@ type ResolveResponse struct {
 	Query         *ResolveQuery `json:"query"`
 }
 
+// 0meta.txt, which wiki and page a dump is of
+type DumpMeta struct {
+	Wiki    *history.Wiki    `json:"wiki"`
+	PageID  int              `json:"pageid"`
+	Title   string           `json:"title"`
+	Resolve *ResolveResponse `json:"resolve"`
+}
+
 // >>>>>
 
 type RevisionMeta struct {
@@ -61,6 +70,12 @@ type RevisionMeta struct {
 	User      string    `json:"user"`
 	UserID    int       `json:"userid"`
 	Comment   string    `json:"comment"`
+	// Logged out edits, temporary accounts and deleted user names
+	Anon       bool     `json:"anon,omitempty"`
+	Temp       bool     `json:"temp,omitempty"`
+	UserHidden bool     `json:"userhidden,omitempty"`
+	SHA1       string   `json:"sha1"`
+	Tags       []string `json:"tags"`
 }
 
 type RevisionIndexPage struct {
@@ -95,6 +110,24 @@ type RevisionIndex struct {
 
 // >>>>>
 
+type CategoryMember struct {
+	PageID    int    `json:"pageid"`
+	NameSpace int    `json:"ns"`
+	Title     string `json:"title"`
+}
+
+type CategoryMembersQuery struct {
+	CategoryMembers []*CategoryMember `json:"categorymembers"`
+}
+
+// Category Members Page
+type CategoryMembers struct {
+	*Debug
+	Query *CategoryMembersQuery `json:"query"`
+}
+
+// >>>>>
+
 type RevisionContentSlotsMain struct {
 	ContentModel  string `json:"contentmodel"`
 	ContentFormat string `json:"contentformat"`
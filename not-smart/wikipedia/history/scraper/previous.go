@@ -0,0 +1,225 @@
+package scraper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The last revision batch saved by saveRevs, as written to 0config.txt
+type Checkpoint struct {
+	RevID    int
+	ParentID int
+}
+
+func readCheckpoint(fPath string) (*Checkpoint, error) {
+	data, err := os.ReadFile(fPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	str := strings.TrimSpace(string(data))
+	if str == "" {
+		return nil, nil
+	}
+
+	revStr, parentStr, ok := strings.Cut(str, "-")
+	if !ok {
+		return nil, fmt.Errorf("malformed checkpoint: %q", str)
+	}
+	cp := new(Checkpoint)
+	if cp.RevID, err = strconv.Atoi(revStr); err != nil {
+		return nil, fmt.Errorf("malformed checkpoint: %q", str)
+	}
+	if cp.ParentID, err = strconv.Atoi(parentStr); err != nil {
+		return nil, fmt.Errorf("malformed checkpoint: %q", str)
+	}
+
+	return cp, nil
+}
+
+// Replaces 0config.txt through a temp file, a crash leaves the previous checkpoint
+func writeCheckpoint(fPath string, cp *Checkpoint) error {
+	tmpPath := fPath + ".tmp"
+	if err := os.WriteFile(tmpPath, fmt.Appendf(nil, "%d-%d", cp.RevID, cp.ParentID), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, fPath)
+}
+
+// Reads a "[meta, meta, ...]" index, keeping every entry that was written completely.
+// A run killed mid-write leaves the array unterminated, the tail is dropped.
+func readIdsIndex(fPath string) ([]*RevisionMeta, error) {
+	f, err := os.Open(fPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	revisions := make([]*RevisionMeta, 0)
+	dec := json.NewDecoder(f)
+	if _, err = dec.Token(); err != nil {
+		if errors.Is(err, io.EOF) {
+			return revisions, nil
+		}
+		return nil, err
+	}
+	for dec.More() {
+		meta := new(RevisionMeta)
+		if err := dec.Decode(meta); err != nil {
+			break
+		}
+		revisions = append(revisions, meta)
+	}
+
+	return revisions, nil
+}
+
+// Splits a "<timestamp>-<revid>.json" name from revs/
+func parseRevFileName(name string) (ts int64, id int, ok bool) {
+	if !strings.HasSuffix(name, ".json") {
+		return 0, 0, false
+	}
+	tsStr, idStr, ok := strings.Cut(strings.TrimSuffix(name, ".json"), "-")
+	if !ok {
+		return 0, 0, false
+	}
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	id, err = strconv.Atoi(idStr)
+	if err != nil {
+		return 0, 0, false
+	}
+	return ts, id, true
+}
+
+// Lists the revision IDs' already saved in revs/
+func readRevsOnDisk(revsDir string) (map[int]struct{}, error) {
+	entries, err := os.ReadDir(revsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	onDisk := make(map[int]struct{}, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if _, id, ok := parseRevFileName(entry.Name()); ok {
+			onDisk[id] = struct{}{}
+		}
+	}
+
+	return onDisk, nil
+}
+
+// The newest revision saved in revs/, 0 if there's none
+func newestRevOnDisk(revsDir string) (int, error) {
+	entries, err := os.ReadDir(revsDir)
+	if err != nil {
+		return 0, err
+	}
+
+	var newestTs int64
+	newestID := 0
+	for _, entry := range entries {
+		ts, id, ok := parseRevFileName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if ts > newestTs || (ts == newestTs && id > newestID) {
+			newestTs, newestID = ts, id
+		}
+	}
+
+	return newestID, nil
+}
+
+func (s *Scraper) loadPrevious() error {
+	var err error
+
+	if s.checkpoint, err = readCheckpoint(s.configFilePath); err != nil {
+		return err
+	}
+	if s.revsOnDisk, err = readRevsOnDisk(s.revsDir); err != nil {
+		return err
+	}
+	if s.prevIds, err = readIdsIndex(s.idsFilePath); err != nil {
+		return err
+	}
+	for _, meta := range s.prevIds {
+		s.prevIndex[meta.RevID] = struct{}{}
+	}
+
+	// The index and revs/ decide what's fetched, the checkpoint tells where the last run got to
+	// and catches a dump that was touched since
+	if s.checkpoint != nil {
+		if _, ok := s.prevIndex[s.checkpoint.RevID]; !ok && len(s.prevIds) > 0 {
+			s.debugger.Debug(fmt.Sprintf("checkpoint revision %d is not in 0ids.json", s.checkpoint.RevID))
+		}
+		if _, ok := s.revsOnDisk[s.checkpoint.RevID]; !ok {
+			s.debugger.Debug(fmt.Sprintf("checkpoint revision %d is not in revs/", s.checkpoint.RevID))
+		}
+		s.debugger.Print("\nLast run saved up to revision %d (parent %d)\n", s.checkpoint.RevID, s.checkpoint.ParentID)
+	}
+	s.debugger.Print("Found %d indexed revisions, %d already on disk\n", len(s.prevIds), len(s.revsOnDisk))
+
+	return nil
+}
+
+// Where an update starts. The index is written before the content,
+// so its newest entry wins, revs/ is only used when there's no index.
+func (s *Scraper) newestKnown() (int, error) {
+	if len(s.prevIds) > 0 {
+		return s.prevIds[0].RevID, nil
+	}
+	return newestRevOnDisk(s.revsDir)
+}
+
+// What a scrape left in its dump directory
+type DumpStatus struct {
+	// The last batch saved, nil before the first one
+	Checkpoint *Checkpoint
+	// Revisions in 0ids.json
+	Indexed int
+	// Revisions saved in revs/
+	OnDisk int
+	// The index reached the first revision of the page
+	IndexComplete bool
+}
+
+func ReadDumpStatus(dumpDir string) (*DumpStatus, error) {
+	var err error
+	status := new(DumpStatus)
+
+	if status.Checkpoint, err = readCheckpoint(filepath.Join(dumpDir, "0config.txt")); err != nil {
+		return nil, err
+	}
+	ids, err := readIdsIndex(filepath.Join(dumpDir, "0ids.json"))
+	if err != nil {
+		return nil, err
+	}
+	status.Indexed = len(ids)
+	status.IndexComplete = len(ids) > 0 && ids[len(ids)-1].ParentID == 0
+
+	onDisk, err := readRevsOnDisk(filepath.Join(dumpDir, "revs"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	status.OnDisk = len(onDisk)
+
+	return status, nil
+}
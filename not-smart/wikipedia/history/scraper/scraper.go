@@ -6,13 +6,11 @@ import (
 	"evolve/debugger"
 	"evolve/wikipedia/history"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
-	"strconv"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -21,49 +19,108 @@ import (
 type Metrics struct {
 	PagesFetched int `json:"Pages Fetched"`
 	RevsFetched  int `json:"Revs Fetched"`
+	RevsSkipped  int `json:"Revs Skipped"`
 }
 
-type Scraper struct {
-	ROOT_URL string
-	BASE_URL string
-	PAGE_URL *url.URL
-	REVS_URL *url.URL
+// Options of a scrape, the zero value scrapes the whole history from scratch
+type Options struct {
+	// Continue a previous run from its index, fetching what it didn't save yet
+	Resume bool
+	// Only fetch the revisions newer than the newest one already in the dump, e.g. for a daily refresh.
+	// They're paged oldest first, so an interrupted update continues where it stopped.
+	Update bool
+	// Only revisions saved within [Since, Until], zero for unbounded.
+	// On update Since only matters for an empty dump.
+	Since time.Time
+	Until time.Time
+
+	// When set every saved revision is also sent there with its index entry, e.g. to the preprocessor.
+	// The history is then paged oldest first, so parents arrive before their children.
+	// Out is closed once the scrape is over.
+	Out chan<- *Revision
+}
+
+// A revision saved to revs/, as streamed to Options.Out
+type Revision struct {
+	Meta    *RevisionMeta
+	Content *RevisionContent
+}
 
+type Scraper struct {
 	pageID    int
 	pageTitle string
 
+	// prop=revisions queries for the history listing and the content batches
+	pageQuery *history.RevisionsQuery
+	revsQuery *history.RevisionsQuery
+
 	dumpDir        string
 	revsDir        string
 	configFilePath string
 	metaFilePath   string
 	idsFilePath    string
 
+	// Resume and update state, loaded from a previous run's dump
+	resume bool
+	update bool
+	// Paging oldest first, on update or when streaming
+	newer      bool
+	checkpoint *Checkpoint
+	prevIds    []*RevisionMeta
+	prevIndex  map[int]struct{}
+	revsOnDisk map[int]struct{}
+
+	// Index entries of the revisions waiting for their content, only kept when streaming
+	out       chan<- *Revision
+	pending   map[int]*RevisionMeta
+	pendingMu sync.Mutex
+
 	ctx    context.Context
 	cancel context.CancelFunc
 	grpctx context.Context
 	grp    *errgroup.Group
+	// Closed once every stage returned, err is the first error of the group
+	done chan struct{}
+	err  error
 
-	idChan      chan int
-	revsUrlChan chan string
-	revsChan    chan *RevisionsContentBatch
-	idsSaveChan chan *RevisionMeta
+	idChan        chan int
+	revsQueryChan chan *history.RevisionsQuery
+	revsChan      chan *RevisionsContentBatch
+	idsSaveChan   chan *RevisionMeta
 
+	client   *history.Client
 	metrics  *Metrics
 	debugger *debugger.Debugger
 }
 
-func NewWikiScrape(title, rootDumpDir string, debugger *debugger.Debugger) (*Scraper, error) {
-	s := &Scraper{
-		ROOT_URL: history.ROOT_URL,
-		metrics:  new(Metrics),
-		debugger: debugger,
+func NewWikiScrape(title, rootDumpDir string, opts *Options, client *history.Client, debugger *debugger.Debugger) (*Scraper, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	if !opts.Since.IsZero() && !opts.Until.IsZero() && opts.Until.Before(opts.Since) {
+		return nil, fmt.Errorf("until is before since")
+	}
+	if opts.Resume && opts.Update {
+		return nil, fmt.Errorf("resume and update are exclusive")
+	}
+	// Resuming continues paging newest first, an interrupted stream is continued with update
+	if opts.Resume && opts.Out != nil {
+		return nil, fmt.Errorf("resume can't be streamed, use update")
 	}
-	var err error
 
-	s.BASE_URL, err = s.prepareRootURLs()
-	if err != nil {
-		return nil, err
+	s := &Scraper{
+		resume:     opts.Resume,
+		update:     opts.Update,
+		newer:      opts.Update || opts.Out != nil,
+		out:        opts.Out,
+		pending:    make(map[int]*RevisionMeta),
+		prevIndex:  make(map[int]struct{}),
+		revsOnDisk: make(map[int]struct{}),
+		client:     client,
+		metrics:    new(Metrics),
+		debugger:   debugger,
 	}
+	var err error
 
 	resolve, err := s.resolveTitle(title)
 	if err != nil {
@@ -72,22 +129,30 @@ func NewWikiScrape(title, rootDumpDir string, debugger *debugger.Debugger) (*Scr
 	s.pageID = resolve.Query.Pages[0].PageID
 	s.pageTitle = resolve.Query.Pages[0].Title
 
-	s.PAGE_URL, err = s.preparePageURL()
-	if err != nil {
-		return nil, err
+	s.pageQuery = &history.RevisionsQuery{
+		PageID: s.pageID,
+		Props:  []string{"ids", "timestamp", "size", "user", "userid", "comment", "sha1", "tags"},
+		Slots:  "main",
+		Limit:  "500",
+		// Newest first, so the window starts at Until and ends at Since
+		Start: opts.Until,
+		End:   opts.Since,
 	}
-	s.REVS_URL, err = s.prepareRevsURL()
-	if err != nil {
-		return nil, err
+	s.revsQuery = &history.RevisionsQuery{
+		Props: []string{"ids", "timestamp", "content", "user", "comment"},
+		Slots: "main",
 	}
 
-	s.dumpDir = filepath.Join(rootDumpDir, title)
+	if err = migrateDumpDir(rootDumpDir, client.Wiki(), title); err != nil {
+		return nil, err
+	}
+	s.dumpDir = DumpDir(rootDumpDir, client.Wiki(), title)
 	err = os.MkdirAll(s.dumpDir, 0700)
 	if err != nil {
 		return nil, err
 	}
 
-	s.revsDir = filepath.Join(rootDumpDir, title, "revs")
+	s.revsDir = filepath.Join(s.dumpDir, "revs")
 	err = os.MkdirAll(s.revsDir, 0700)
 	if err != nil {
 		return nil, err
@@ -95,121 +160,96 @@ func NewWikiScrape(title, rootDumpDir string, debugger *debugger.Debugger) (*Scr
 	s.configFilePath = filepath.Join(s.dumpDir, "0config.txt")
 	s.metaFilePath = filepath.Join(s.dumpDir, "0meta.txt")
 	s.idsFilePath = filepath.Join(s.dumpDir, "0ids.json")
-	err = s.saveMeta(resolve)
+	err = s.saveMeta(client.Wiki(), resolve)
 	if err != nil {
 		return nil, err
 	}
 
+	if s.resume || s.update {
+		if err = s.loadPrevious(); err != nil {
+			return nil, err
+		}
+	}
+	if s.newer {
+		s.pageQuery.Dir = "newer"
+		s.pageQuery.Start = opts.Since
+		s.pageQuery.End = opts.Until
+	}
+	if s.update {
+		newest, err := s.newestKnown()
+		if err != nil {
+			return nil, err
+		}
+		if newest != 0 {
+			// rvstart can't be combined with rvstartid
+			s.pageQuery.StartID = newest
+			s.pageQuery.Start = time.Time{}
+			s.debugger.Print("\nUpdating after revision %d\n", newest)
+		}
+	}
+
 	s.idChan = make(chan int, 60)
 	s.idsSaveChan = make(chan *RevisionMeta, 60)
-	s.revsUrlChan = make(chan string, 5)
+	s.revsQueryChan = make(chan *history.RevisionsQuery, 5)
 	s.revsChan = make(chan *RevisionsContentBatch, 10)
 
 	return s, nil
 }
 
-// Prepare
-func (s *Scraper) prepareRootURLs() (string, error) {
-	u, err := url.Parse(s.ROOT_URL)
-	if err != nil {
-		return "", err
-	}
-
-	baseQueries := map[string]string{
-		"action":        "query",
-		"format":        "json",
-		"formatversion": "2",
-	}
-	params := url.Values{}
-	for key, val := range baseQueries {
-		params.Set(key, val)
-	}
-	u.RawQuery = params.Encode()
-
-	return u.String(), nil
+// The dump directory of a title, under one directory per wiki since titles clash across them.
+// "/" in titles would nest directories.
+func DumpDir(rootDumpDir string, wiki *history.Wiki, title string) string {
+	return filepath.Join(WikiDumpDir(rootDumpDir, wiki), strings.ReplaceAll(title, "/", "_"))
 }
 
-func (s *Scraper) preparePageURL() (*url.URL, error) {
-	u, err := url.Parse(s.BASE_URL)
-	if err != nil {
-		return nil, err
+// Before there were several wikis English Wikipedia was dumped to <root>/wikipedia/<title>.
+// Empty for the other wikis or if there's no such directory.
+func legacyDumpDir(rootDumpDir string, wiki *history.Wiki, title string) string {
+	if wiki.ID() != history.DefaultWiki().ID() {
+		return ""
 	}
-
-	pageQueries := map[string]string{
-		"pageids":   strconv.Itoa(s.pageID),
-		"prop":      "revisions",
-		"rvslots":   "main",
-		"rvprop":    "ids|timestamp|size|user|userid|comment",
-		"rvlimit":   "500",
-		"redirects": "1",
-	}
-	params := u.Query()
-	for key, val := range pageQueries {
-		params.Set(key, val)
+	legacy := filepath.Join(rootDumpDir, "wikipedia", title)
+	if info, err := os.Stat(filepath.Join(legacy, "0ids.json")); err != nil || info.IsDir() {
+		return ""
 	}
-	u.RawQuery = params.Encode()
-
-	return u, nil
+	return legacy
 }
 
-func (s *Scraper) prepareRevsURL() (*url.URL, error) {
-	u, err := url.Parse(s.BASE_URL)
-	if err != nil {
-		return nil, err
+// Moves a dump of the old layout to its DumpDir, unless there's already one there.
+// Only scraping does it, the other commands find the dump where it was scraped to.
+func migrateDumpDir(rootDumpDir string, wiki *history.Wiki, title string) error {
+	legacy := legacyDumpDir(rootDumpDir, wiki, title)
+	if legacy == "" {
+		return nil
 	}
-
-	revQueries := map[string]string{
-		"revids":  "1",
-		"prop":    "revisions",
-		"rvslots": "main",
-		"rvprop":  "ids|timestamp|content|user|comment",
+	dumpDir := DumpDir(rootDumpDir, wiki, title)
+	if _, err := os.Stat(dumpDir); err == nil {
+		fmt.Printf("Both %s and %s exist, leaving the old one alone\n", legacy, dumpDir)
+		return nil
 	}
-	params := u.Query()
-	for key, val := range revQueries {
-		params.Set(key, val)
+	if err := os.MkdirAll(filepath.Dir(dumpDir), 0700); err != nil {
+		return err
+	}
+	if err := os.Rename(legacy, dumpDir); err != nil {
+		return fmt.Errorf("moving %s to %s: %w", legacy, dumpDir, err)
 	}
-	u.RawQuery = params.Encode()
+	fmt.Printf("Moved %s to %s\n", legacy, dumpDir)
+	return nil
+}
 
-	return u, nil
+// What the titles of a wiki share lives here, next to their dump directories
+func WikiDumpDir(rootDumpDir string, wiki *history.Wiki) string {
+	return filepath.Join(rootDumpDir, wiki.ID())
 }
 
 // Resolve
 func (s *Scraper) resolveTitle(title string) (*ResolveResponse, error) {
-	u, err := url.Parse(s.BASE_URL)
-	if err != nil {
-		return nil, err
-	}
-	queries := map[string]string{
-		"titles":    title,
-		"redirects": "1",
-	}
-	params := u.Query()
-	for key, val := range queries {
-		params.Set(key, val)
-	}
-	u.RawQuery = params.Encode()
-
-	client := http.Client{}
-	url := u.String()
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("User-Agent", history.USER_AGENT)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	query := &history.TitlesQuery{
+		Titles: []string{title},
 	}
 
 	resolve := new(ResolveResponse)
-	err = json.Unmarshal(body, resolve)
+	_, err := s.client.Query(context.Background(), query, nil, resolve)
 	if err != nil {
 		return nil, err
 	}
@@ -217,28 +257,26 @@ func (s *Scraper) resolveTitle(title string) (*ResolveResponse, error) {
 	if resolve.Query == nil || resolve.Query.Pages == nil || len(resolve.Query.Pages) == 0 {
 		return nil, fmt.Errorf("resolve is empty")
 	}
+	if page := resolve.Query.Pages[0]; page.Missing || page.Invalid {
+		return nil, fmt.Errorf("page %q doesn't exist", title)
+	}
 
 	return resolve, nil
 }
 
-func (s *Scraper) saveMeta(resolve *ResolveResponse) error {
-	metaF, err := os.OpenFile(s.metaFilePath, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		return err
-	}
-	defer metaF.Close()
-
-	resolveMarshaled, err := json.MarshalIndent(resolve, "", " ")
-	if err != nil {
-		return err
+func (s *Scraper) saveMeta(wiki *history.Wiki, resolve *ResolveResponse) error {
+	meta := &DumpMeta{
+		Wiki:    wiki,
+		PageID:  s.pageID,
+		Title:   s.pageTitle,
+		Resolve: resolve,
 	}
-
-	_, err = metaF.Write(resolveMarshaled)
+	metaMarshaled, err := json.MarshalIndent(meta, "", " ")
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return os.WriteFile(s.metaFilePath, metaMarshaled, 0644)
 }
 
 func (s *Scraper) Run() error {
@@ -280,18 +318,37 @@ func (s *Scraper) Run() error {
 		return err
 	})
 
+	// A failing stage cancels the rest, so nothing blocks on a channel nobody reads
+	s.done = make(chan struct{})
+	go func() {
+		<-s.grpctx.Done()
+		s.cancel()
+	}()
+	go func() {
+		s.err = s.grp.Wait()
+		s.cancel()
+		close(s.done)
+	}()
+
 	return nil
 }
 
+// Done is closed once the scrape is over, finished or stopped
+func (s *Scraper) Done() <-chan struct{} {
+	return s.done
+}
+
+// Wait blocks until the scrape is over and returns its first error
+func (s *Scraper) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Stop cancels the scrape early, what was fetched is still saved
 func (s *Scraper) Stop() error {
 	s.cancel()
 
-	err := s.grp.Wait()
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return s.Wait()
 }
 
 func (s *Scraper) PrintMetrics() error {
@@ -309,98 +366,99 @@ func (s *Scraper) PrintMetrics() error {
 
 // Fetch IDs'
 func (s *Scraper) fetchIds() error {
-	client := http.Client{}
-
-	continuationVal := ""
-	continuationParam := "rvcontinue"
+	defer close(s.idChan)
+	defer close(s.idsSaveChan)
 
-	url := *s.PAGE_URL
-	params := url.Query()
+	query := *s.pageQuery
 
-outer:
-	for {
+	// Push the revisions a previous run indexed but never fetched the content for
+	for _, revMeta := range s.prevIds {
+		if _, ok := s.revsOnDisk[revMeta.RevID]; ok {
+			s.metrics.RevsSkipped += 1
+			continue
+		}
+		s.addPending(revMeta)
 		select {
 		case <-s.ctx.Done():
-			break outer
-		default:
-			// Add continuation param and prepare URL and Request
-			if continuationVal != "" {
-				params.Set(continuationParam, continuationVal)
-				url.RawQuery = params.Encode()
-			}
-			pageurl := url.String()
-			req, err := http.NewRequest("GET", pageurl, nil)
-			if err != nil {
-				return err
-			}
-			req.Header.Set("User-Agent", history.USER_AGENT)
+			return nil
+		case s.idChan <- revMeta.RevID:
+		}
+	}
 
-			// Fetch the page
-			fmt.Printf("Fetching page: %s: ", pageurl)
-			resp, err := client.Do(req)
-			if err != nil {
-				return err
-			}
-			fmt.Printf("%d\n", resp.StatusCode)
+	// Continue the pagination right after the oldest revision that was indexed
+	if s.resume && len(s.prevIds) > 0 {
+		oldest := s.prevIds[len(s.prevIds)-1]
+		if oldest.ParentID == 0 {
+			s.debugger.Print("\nIndex is already complete, nothing left to page through.\n")
+			return nil
+		}
+		// rvstart can't be combined with rvstartid, the start is the oldest indexed anyway
+		query.StartID = oldest.ParentID
+		query.Start = time.Time{}
+	}
 
-			// Read the response, status
-			pageBody, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return err
-			}
-			page := new(RevisionIndex)
-			err = json.Unmarshal(pageBody, page)
-			if err != nil {
-				return fmt.Errorf("pageBody unmarshall error: %v", err)
-			}
-			if page.Query == nil || page.Query.Pages == nil {
-				return fmt.Errorf("Page is nil")
-			}
-			firstPage := page.Query.Pages[0]
+	err := history.Paginate(s.ctx, s.client, &query, nil, func(page *RevisionIndex, next history.Continuation) error {
+		if page.Query == nil || page.Query.Pages == nil {
+			return fmt.Errorf("Page is nil")
+		}
+		firstPage := page.Query.Pages[0]
 
-			// Push all IDs to the channel
-			for _, revMeta := range firstPage.Revisions {
-				s.idChan <- revMeta.RevID
-				s.idsSaveChan <- revMeta
+		// Push all IDs to the channel
+		for _, revMeta := range firstPage.Revisions {
+			// An update starts at the newest indexed revision, which was pushed above
+			if _, ok := s.prevIndex[revMeta.RevID]; ok {
+				continue
 			}
-			s.metrics.PagesFetched += 1
-
-			if page.Continue != nil {
-				continuationVal = page.Continue.RvContinue
+			if _, ok := s.revsOnDisk[revMeta.RevID]; ok {
+				s.metrics.RevsSkipped += 1
 			} else {
-				break outer
+				s.addPending(revMeta)
+				select {
+				case <-s.ctx.Done():
+					return s.ctx.Err()
+				case s.idChan <- revMeta.RevID:
+				}
+			}
+			select {
+			case <-s.ctx.Done():
+				return s.ctx.Err()
+			case s.idsSaveChan <- revMeta:
 			}
 		}
-	}
+		s.metrics.PagesFetched += 1
+		fmt.Printf("%s: fetched page %d: %d revisions\n", s.pageTitle, s.metrics.PagesFetched, len(firstPage.Revisions))
 
-	close(s.idChan)
-	close(s.idsSaveChan)
+		return nil
+	})
+	// Stopping is not an error, the channels are closed the same way
+	if err != nil && s.ctx.Err() == nil {
+		return fmt.Errorf("page fetch error: %w", err)
+	}
 
 	return nil
 }
 
 // Prepare Rev params
 func (s *Scraper) prepareRevParam() error {
+	defer close(s.revsQueryChan)
+
 	batch := make([]int, 0)
 	batchLen := 20
-	revIdsParam := "revids"
 
-	url := *s.REVS_URL
-	params := url.Query()
+	process := func() *history.RevisionsQuery {
+		query := *s.revsQuery
+		query.RevIDs = append([]int(nil), batch...)
+		return &query
+	}
 
-	process := func() string {
-		// Concatenate IDs and return the URL string
-		var sb strings.Builder
-		for i := 0; i < len(batch); i++ {
-			if i > 0 {
-				sb.WriteByte('|')
-			}
-			sb.WriteString(strconv.Itoa(batch[i]))
+	send := func() bool {
+		select {
+		case <-s.ctx.Done():
+			return false
+		case s.revsQueryChan <- process():
+			batch = batch[:0]
+			return true
 		}
-		params.Set(revIdsParam, sb.String())
-		url.RawQuery = params.Encode()
-
-		return url.String()
 	}
 
 	for id := range s.idChan {
@@ -408,114 +466,120 @@ func (s *Scraper) prepareRevParam() error {
 		batch = append(batch, id)
 
 		// Process batch if limit hit
-		if len(batch) == batchLen {
-			urlStr := process()
-			s.revsUrlChan <- urlStr
-			batch = batch[:0]
+		if len(batch) == batchLen && !send() {
+			return nil
 		}
 	}
 
 	if len(batch) > 0 {
-		urlStr := process()
-		s.revsUrlChan <- urlStr
+		send()
 	}
 
-	close(s.revsUrlChan)
-
 	return nil
 }
 
 // Fetch Revs
 func (s *Scraper) fetchRevs() error {
-	client := http.Client{}
-	rate := 3                     // Max number of requests in 1 second
-	timeBtn := int64(1000 / rate) // Minimum time gap between 2 requests to maintain rate
-	lastReq := int64(0)           // UnixMilliseconds of last request
-
-	for url := range s.revsUrlChan {
-		// Fetch the URL
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return err
-		}
-		req.Header.Set("User-Agent", history.USER_AGENT)
-
-		diff := time.Now().UnixMilli() - lastReq
-		if diff < timeBtn {
-			time.Sleep(time.Duration(timeBtn-diff) * time.Millisecond)
-		}
-
-		fmt.Printf("Fetching revision: %s : ", url)
+	defer close(s.revsChan)
 
-		lastReq = time.Now().UnixMilli()
-		resp, err := client.Do(req)
-		if err != nil {
-			return err
-		}
-		fmt.Printf("%dms : %d\n", time.Now().UnixMilli()-lastReq, resp.StatusCode)
-
-		// TODO: proper fallback and wait
-		if resp.StatusCode != 200 {
-			return fmt.Errorf("status non-200")
-		}
-		revBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
+	for query := range s.revsQueryChan {
+		// Fetch the batch, the client keeps the rate limit
+		start := time.Now().UnixMilli()
 		allRevs := new(RevisionsContentBatch)
-		err = json.Unmarshal(revBody, allRevs)
+		_, err := s.client.Query(s.ctx, query, nil, allRevs)
 		if err != nil {
-			return err
+			if s.ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("revisions fetch error: %w", err)
 		}
+		fmt.Printf("%s: fetched %d revisions : %dms\n", s.pageTitle, len(query.RevIDs), time.Now().UnixMilli()-start)
 
 		// Send the Revisions to the worker for async saving to disk
-		s.revsChan <- allRevs
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case s.revsChan <- allRevs:
+		}
+	}
+
+	return nil
+}
+
+func (s *Scraper) addPending(meta *RevisionMeta) {
+	if s.out == nil {
+		return
 	}
+	s.pendingMu.Lock()
+	s.pending[meta.RevID] = meta
+	s.pendingMu.Unlock()
+}
 
-	close(s.revsChan)
+// Sends a saved revision downstream, blocking while the consumer is behind
+func (s *Scraper) stream(rev *RevisionContent) error {
+	s.pendingMu.Lock()
+	meta, ok := s.pending[rev.RevID]
+	delete(s.pending, rev.RevID)
+	s.pendingMu.Unlock()
+	if !ok {
+		return fmt.Errorf("revision %d was never indexed", rev.RevID)
+	}
 
+	select {
+	case <-s.ctx.Done():
+		return nil
+	case s.out <- &Revision{Meta: meta, Content: rev}:
+	}
 	return nil
 }
 
 // Save Revs
 func (s *Scraper) saveRevs() error {
-	configF, err := os.OpenFile(s.configFilePath, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		return err
+	if s.out != nil {
+		defer close(s.out)
 	}
-	defer configF.Close()
 
 	for revs := range s.revsChan {
 		if revs.Query == nil || revs.Query.Pages == nil || len(revs.Query.Pages) == 0 {
 			return fmt.Errorf("revs are nil")
 		}
 		revisions := revs.Query.Pages[0].Revisions
+		if len(revisions) == 0 {
+			continue
+		}
 
 		for _, singleRev := range revisions {
 
 			fileName := fmt.Sprintf("%d-%d.json", singleRev.TimeStamp.Unix(), singleRev.RevID)
 			filePath := filepath.Join(s.revsDir, fileName)
-			f, err := os.Create(filePath)
-			if err != nil {
-				return err
-			}
 			revMarshal, err := json.MarshalIndent(singleRev, "", "  ")
 			if err != nil {
 				return fmt.Errorf("revMarshall error: %v", err)
 			}
-			_, err = f.Write(revMarshal)
+			// Write to a temp file first, so a file in revs/ is always complete on resume
+			tmpPath := filePath + ".tmp"
+			err = os.WriteFile(tmpPath, revMarshal, 0644)
+			if err != nil {
+				return err
+			}
+			err = os.Rename(tmpPath, filePath)
 			if err != nil {
 				return err
 			}
-			f.Close()
 
 			s.metrics.RevsFetched += 1
+
+			if s.out != nil {
+				if err = s.stream(singleRev); err != nil {
+					return err
+				}
+			}
 		}
 
-		configF.Seek(0, 0)
-		configF.Truncate(0)
 		lastRev := revisions[len(revisions)-1]
-		fmt.Fprintf(configF, "%d-%d", lastRev.RevID, lastRev.ParentID)
+		if err := writeCheckpoint(s.configFilePath, &Checkpoint{RevID: lastRev.RevID, ParentID: lastRev.ParentID}); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -523,7 +587,11 @@ func (s *Scraper) saveRevs() error {
 
 // Save the Index
 func (s *Scraper) saveIds() error {
-	idsF, err := os.OpenFile(s.idsFilePath, os.O_RDWR|os.O_CREATE, 0644)
+	if s.newer {
+		return s.saveIdsNewer()
+	}
+
+	idsF, err := os.OpenFile(s.idsFilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
 	}
@@ -540,6 +608,23 @@ func (s *Scraper) saveIds() error {
 
 	first := true
 
+	// Rewrite what the previous run indexed before appending the new pages
+	for _, rev := range s.prevIds {
+		marshal, err := json.MarshalIndent(rev, "", " ")
+		if err != nil {
+			return err
+		}
+		if first {
+			first = false
+		} else {
+			idsF.Write([]byte(",\n"))
+		}
+		_, err = idsF.Write(marshal)
+		if err != nil {
+			return err
+		}
+	}
+
 	for rev := range s.idsSaveChan {
 		marshal, err := json.MarshalIndent(rev, "", " ")
 		if err != nil {
@@ -558,3 +643,45 @@ func (s *Scraper) saveIds() error {
 
 	return nil
 }
+
+// Paging oldest first, the index still has to stay newest first with the new revisions on top.
+// It's rewritten whole every few hundred entries, so a crash never leaves the old entries half written.
+func (s *Scraper) saveIdsNewer() error {
+	newer := make([]*RevisionMeta, 0)
+	written := 0
+
+	write := func() error {
+		if len(newer) == written {
+			return nil
+		}
+		all := slices.Clone(newer)
+		slices.Reverse(all)
+		all = append(all, s.prevIds...)
+
+		marshal, err := json.MarshalIndent(all, "", " ")
+		if err != nil {
+			return err
+		}
+		tmpPath := s.idsFilePath + ".tmp"
+		if err = os.WriteFile(tmpPath, marshal, 0644); err != nil {
+			return err
+		}
+		written = len(newer)
+		return os.Rename(tmpPath, s.idsFilePath)
+	}
+
+	for rev := range s.idsSaveChan {
+		newer = append(newer, rev)
+		if len(newer)-written >= 500 {
+			if err := write(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := write(); err != nil {
+		return err
+	}
+	s.debugger.Print("\n%s: %d new revisions indexed\n", s.pageTitle, len(newer))
+
+	return nil
+}
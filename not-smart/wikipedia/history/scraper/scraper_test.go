@@ -0,0 +1,86 @@
+package scraper
+
+import (
+	"evolve/wikipedia/history"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Dumps of the old dump/wikipedia/<title> layout move under the wiki's directory when scraped
+func TestMigrateLegacyDumpDir(t *testing.T) {
+	root := t.TempDir()
+	legacy := filepath.Join(root, "wikipedia", "Machine learning")
+	if err := os.MkdirAll(filepath.Join(legacy, "revs"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(legacy, "0ids.json"), []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only a path, nothing moves
+	want := filepath.Join(root, "en.wikipedia.org", "Machine learning")
+	if dir := DumpDir(root, history.DefaultWiki(), "Machine learning"); dir != want {
+		t.Fatalf("DumpDir = %s, want %s", dir, want)
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		t.Fatalf("DumpDir moved the old directory: %v", err)
+	}
+
+	other, err := history.ParseWiki("de.wikipedia.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = migrateDumpDir(root, other, "Machine learning"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(legacy); err != nil {
+		t.Fatalf("the dump of another wiki was moved: %v", err)
+	}
+
+	if err = migrateDumpDir(root, history.DefaultWiki(), "Machine learning"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(want, "0ids.json")); err != nil {
+		t.Errorf("not moved: %v", err)
+	}
+	if _, err = os.Stat(legacy); err == nil {
+		t.Error("the old directory is still there")
+	}
+	// Once moved there's nothing left to do
+	if err = migrateDumpDir(root, history.DefaultWiki(), "Machine learning"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	fPath := filepath.Join(t.TempDir(), "0config.txt")
+
+	// Nothing saved yet
+	if cp, err := readCheckpoint(fPath); cp != nil || err != nil {
+		t.Fatalf("no file: %v, %v", cp, err)
+	}
+
+	for _, want := range []Checkpoint{{RevID: 1200, ParentID: 1100}, {RevID: 900, ParentID: 0}} {
+		if err := writeCheckpoint(fPath, &want); err != nil {
+			t.Fatal(err)
+		}
+		cp, err := readCheckpoint(fPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *cp != want {
+			t.Errorf("read %+v, want %+v", *cp, want)
+		}
+	}
+	if _, err := os.Stat(fPath + ".tmp"); err == nil {
+		t.Error("the temp file is left")
+	}
+
+	if err := os.WriteFile(fPath, []byte("1200"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCheckpoint(fPath); err == nil {
+		t.Error("no error for a malformed checkpoint")
+	}
+}
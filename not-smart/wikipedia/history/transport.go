@@ -0,0 +1,138 @@
+package history
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A recorded API response, one file per distinct request
+type Recording struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status"`
+	RetryAfter string `json:"retryAfter,omitempty"`
+	Body       string `json:"body"`
+}
+
+// Requests differing only in maxlag are the same recording
+func recordingKey(req *http.Request) string {
+	params := req.URL.Query()
+	params.Del("maxlag")
+	sum := sha1.Sum([]byte(req.URL.Hostname() + req.URL.Path + "?" + params.Encode()))
+	return hex.EncodeToString(sum[:])
+}
+
+// RecordTransport passes requests through to Base and saves every response in Dir
+type RecordTransport struct {
+	Base http.RoundTripper
+	Dir  string
+
+	mu sync.Mutex
+}
+
+func NewRecordTransport(dir string) (*RecordTransport, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &RecordTransport{
+		Base: http.DefaultTransport,
+		Dir:  dir,
+	}, nil
+}
+
+func (s *RecordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := s.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	// Throttled and failed responses are retried, only keep what the retry ends with
+	if resp.StatusCode == http.StatusOK {
+		rec := &Recording{
+			URL:        req.URL.String(),
+			StatusCode: resp.StatusCode,
+			RetryAfter: resp.Header.Get("Retry-After"),
+			Body:       string(body),
+		}
+		data, err := json.MarshalIndent(rec, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		s.mu.Lock()
+		err = os.WriteFile(filepath.Join(s.Dir, recordingKey(req)+".json"), data, 0644)
+		s.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+// ReplayTransport serves the responses saved by a RecordTransport, never touching the network.
+// A request that was never recorded gets a 404, which the client doesn't retry.
+type ReplayTransport struct {
+	Dir string
+}
+
+func NewReplayTransport(dir string) (*ReplayTransport, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("replay dir is not a directory: %s", dir)
+	}
+	return &ReplayTransport{Dir: dir}, nil
+}
+
+func (s *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.Dir, recordingKey(req)+".json"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		resp.StatusCode = http.StatusNotFound
+		resp.Status = fmt.Sprintf("%d no recording", resp.StatusCode)
+		resp.Body = io.NopCloser(bytes.NewReader(nil))
+		return resp, nil
+	}
+
+	rec := new(Recording)
+	if err = json.Unmarshal(data, rec); err != nil {
+		return nil, fmt.Errorf("recording unmarshall error: %v", err)
+	}
+	resp.StatusCode = rec.StatusCode
+	resp.Status = http.StatusText(rec.StatusCode)
+	resp.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if rec.RetryAfter != "" {
+		resp.Header.Set("Retry-After", rec.RetryAfter)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader([]byte(rec.Body)))
+	resp.ContentLength = int64(len(rec.Body))
+
+	return resp, nil
+}
@@ -0,0 +1,65 @@
+package history
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// A MediaWiki installation, any language or project of Wikipedia or a self-hosted one
+type Wiki struct {
+	// e.g. en.wikipedia.org
+	Host string `json:"host"`
+	// e.g. https://en.wikipedia.org/w/api.php
+	APIURL string `json:"apiURL"`
+}
+
+// Takes a host, which gets the standard /w/api.php path, or the full URL of api.php
+func ParseWiki(str string) (*Wiki, error) {
+	if !strings.Contains(str, "://") {
+		if str == "" || strings.ContainsAny(str, "/?#") {
+			return nil, fmt.Errorf("bad wiki host %q", str)
+		}
+		return &Wiki{
+			Host:   str,
+			APIURL: "https://" + str + standardAPIPath,
+		}, nil
+	}
+
+	u, err := url.Parse(str)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("bad wiki api url %q", str)
+	}
+	return &Wiki{
+		Host:   u.Host,
+		APIURL: str,
+	}, nil
+}
+
+// A Wikimedia project in some language, e.g. ("de", "wiktionary")
+func WikimediaWiki(lang, project string) (*Wiki, error) {
+	return ParseWiki(fmt.Sprintf("%s.%s.org", lang, project))
+}
+
+func DefaultWiki() *Wiki {
+	wiki, _ := ParseWiki(ROOT_URL)
+	return wiki
+}
+
+// The api.php path every Wikimedia wiki uses
+const standardAPIPath = "/w/api.php"
+
+// Names the dump directory of the wiki, the host without characters paths don't like.
+// Wikis with another api.php path get the whole path appended, two of them can share a host.
+func (w *Wiki) ID() string {
+	id := w.Host
+	if u, err := url.Parse(w.APIURL); err == nil && u.Path != standardAPIPath {
+		if path := strings.Trim(u.Path, "/"); path != "" {
+			id += "/" + path
+		}
+	}
+	return strings.NewReplacer(":", "_", "/", "_").Replace(id)
+}
@@ -0,0 +1,41 @@
+package history
+
+import "testing"
+
+func TestWikiID(t *testing.T) {
+	tests := []struct {
+		wiki string
+		want string
+	}{
+		{"en.wikipedia.org", "en.wikipedia.org"},
+		{"https://de.wiktionary.org/w/api.php", "de.wiktionary.org"},
+		{"http://127.0.0.1:8080/w/api.php", "127.0.0.1_8080"},
+		{"https://example.org/api.php", "example.org_api.php"},
+		{"https://example.org/wiki/api.php", "example.org_wiki_api.php"},
+		{"https://example.org/team/a/w/api.php", "example.org_team_a_w_api.php"},
+	}
+	for _, test := range tests {
+		wiki, err := ParseWiki(test.wiki)
+		if err != nil {
+			t.Fatalf("%s: %v", test.wiki, err)
+		}
+		if id := wiki.ID(); id != test.want {
+			t.Errorf("%s: ID() = %q, want %q", test.wiki, id, test.want)
+		}
+	}
+}
+
+// Two APIs on one host, one of them at the standard path, don't share a dump directory
+func TestWikiIDSameHost(t *testing.T) {
+	ids := map[string]string{}
+	for _, apiURL := range []string{"https://example.org/w/api.php", "https://example.org/api.php", "https://example.org/w2/api.php"} {
+		wiki, err := ParseWiki(apiURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other, ok := ids[wiki.ID()]; ok {
+			t.Errorf("%s and %s are both %s", other, apiURL, wiki.ID())
+		}
+		ids[wiki.ID()] = apiURL
+	}
+}